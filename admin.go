@@ -0,0 +1,121 @@
+package fdstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//serverConn is a connection tracked by server
+type serverConn struct {
+	inFlight int64 //count of requests in handlers
+	id       uint64
+	client   *AsyncClient
+	peer     *Peer
+	started  time.Time
+}
+
+//ConnInfo describe connection of server
+type ConnInfo struct {
+	ID         uint64
+	RemoteAddr string
+	//Identity is a name of TLS client certificate and Principal is a name given by Authenticator
+	Identity  string `json:",omitempty"`
+	Principal string `json:",omitempty"`
+	Started   time.Time
+	Uptime    time.Duration
+	//LastActivity is a time of last read or written frame, zero if there was no one
+	LastActivity time.Time
+
+	MessagesIn  uint64
+	BytesIn     uint64
+	MessagesOut uint64
+	BytesOut    uint64
+	SendQueue   int
+	ReadQueue   int
+	//InFlight is a count of requests which are handled now
+	InFlight int64
+}
+
+func (c *serverConn) info(now time.Time) ConnInfo {
+	st := c.client.Stats()
+	info := ConnInfo{
+		ID:           c.id,
+		Principal:    c.client.principal,
+		Identity:     c.peer.Identity,
+		Started:      c.started,
+		Uptime:       now.Sub(c.started),
+		LastActivity: st.LastActivity,
+		MessagesIn:   st.MessagesReceived,
+		BytesIn:      st.BytesReceived,
+		MessagesOut:  st.MessagesSent,
+		BytesOut:     st.BytesSent,
+		SendQueue:    st.SendQueue,
+		ReadQueue:    st.ReadQueue,
+		InFlight:     atomic.LoadInt64(&c.inFlight),
+	}
+	if c.peer.Addr != nil {
+		info.RemoteAddr = c.peer.Addr.String()
+	}
+	return info
+}
+
+//Connections return info about open connections of server ordered by ID
+func (s *Server) Connections() []ConnInfo {
+	s.l.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.l.Unlock()
+
+	now := time.Now()
+	infos := make([]ConnInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = c.info(now)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+//CloseConnection shutdown connection by ID, it return false if there is no such connection
+func (s *Server) CloseConnection(id uint64) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for cl, c := range s.conns {
+		if c.id == id {
+			cl.Shutdown()
+			return true
+		}
+	}
+	return false
+}
+
+//AdminHandler return http handler which list connections of server as JSON on GET
+// and close connection on DELETE with query parameter id
+func (s *Server) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.Connections())
+		case http.MethodDelete:
+			id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid connection id", http.StatusBadRequest)
+				return
+			}
+			if !s.CloseConnection(id) {
+				http.Error(w, "Connection not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package fdstream
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConnections(t *testing.T) {
+	as := assert.New(t)
+	release := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(m *Message) *Message {
+		if m.Name == "block" {
+			<-release
+		}
+		return m
+	})}
+	defer s.Close()
+	defer close(release)
+
+	clients := make([]*SyncClient, 2)
+	for i := range clients {
+		a, b := net.Pipe()
+		go s.ServeConn(b)
+		cl, err := NewSyncClient(a, a, time.Second)
+		as.Nil(err)
+		defer cl.Shutdown()
+		clients[i] = cl
+		n := i + 1 //connection gets ID in order of tracking
+		as.True(waitFor(func() bool { return len(s.Connections()) == n }))
+	}
+	_, err := clients[0].Call(context.Background(), &Message{Name: "ping"})
+	as.Nil(err)
+	go clients[1].Call(context.Background(), &Message{Name: "block"})
+
+	as.True(waitFor(func() bool {
+		conns := s.Connections()
+		return len(conns) == 2 && conns[1].InFlight == 1
+	}))
+	conns := s.Connections()
+	as.Equal(uint64(1), conns[0].ID)
+	as.Equal("pipe", conns[0].RemoteAddr)
+	as.Equal(uint64(1), conns[0].MessagesIn)
+	as.True(waitFor(func() bool { return s.Connections()[0].MessagesOut == 1 }))
+	as.False(conns[0].LastActivity.IsZero())
+	as.True(conns[0].Uptime > 0)
+
+	handler := s.AdminHandler()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	as.Equal("application/json", w.Header().Get("Content-Type"))
+	var listed []ConnInfo
+	as.Nil(json.Unmarshal(w.Body.Bytes(), &listed))
+	as.Len(listed, 2)
+	as.Equal(int64(1), listed[1].InFlight)
+
+	for _, tt := range []struct {
+		method, target string
+		code           int
+	}{
+		{"POST", "/", http.StatusMethodNotAllowed},
+		{"DELETE", "/?id=x", http.StatusBadRequest},
+		{"DELETE", "/?id=42", http.StatusNotFound},
+		{"DELETE", "/?id=1", http.StatusNoContent},
+	} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		as.Equal(tt.code, w.Code, tt.method+" "+tt.target)
+	}
+	<-clients[0].Done()
+	as.True(waitFor(func() bool { return len(s.Connections()) == 1 }))
+	as.Equal(uint64(2), s.Connections()[0].ID)
+}
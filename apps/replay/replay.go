@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Asuan/fdstream"
+)
+
+type context struct {
+	server   string
+	capture  string
+	requests string
+	speed    float64
+	timeout  time.Duration
+}
+
+var (
+	ctx    context
+	logger *log.Logger
+)
+
+//exchange is a recorded request with its recorded and replayed responce
+type exchange struct {
+	request  *fdstream.Message
+	at       time.Time
+	expected *fdstream.Message
+	actual   *fdstream.Message
+}
+
+//Initialize flags
+func Initialize() {
+	flag.StringVar(&ctx.server, "server", "0.0.0.0:1900", "address of server")
+	flag.StringVar(&ctx.capture, "capture", "", "capture `file` to replay")
+	flag.StringVar(&ctx.requests, "requests", "out", "direction of requests in capture, out for capture of client and in for capture of server")
+	flag.Float64Var(&ctx.speed, "speed", 1, "speed of replay relative to original timing, 0 send requests without delay")
+	flag.DurationVar(&ctx.timeout, "timeout", 10*time.Second, "time to wait responces after last request")
+
+	flag.Parse()
+
+	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+}
+
+func main() {
+	Initialize()
+
+	direction := fdstream.DirectionOut
+	if ctx.requests == "in" {
+		direction = fdstream.DirectionIn
+	}
+	exchanges, skipped, err := load(ctx.capture, direction)
+	if err != nil {
+		logger.Fatalf("Could not read capture %s: %v", ctx.capture, err)
+	}
+	logger.Printf("Loaded %d requests, skipped %d messages of channels", len(exchanges), skipped)
+
+	conn, err := net.Dial("tcp", ctx.server)
+	if err != nil {
+		logger.Fatalf("Could not connect to %s: %v", ctx.server, err)
+	}
+	cl, err := fdstream.NewAsyncClient(conn, conn)
+	if err != nil {
+		logger.Fatalf("Could not create client: %v", err)
+	}
+	defer cl.Shutdown()
+
+	replay(cl, exchanges)
+	if diff(exchanges) > 0 {
+		os.Exit(1)
+	}
+}
+
+//load read complete messages of capture, responce is a first message of other direction with ID of request
+func load(path string, direction fdstream.Direction) (exchanges []*exchange, skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	cr, err := fdstream.NewCaptureReader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[uint32]*exchange)
+	for {
+		rec, err := cr.NextMessage()
+		if err == io.EOF {
+			return exchanges, skipped, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		m := rec.Message
+		if m.Channel != 0 { //server handle only default channel
+			skipped++
+			continue
+		}
+		if rec.Direction == direction {
+			e := &exchange{request: m, at: rec.Time}
+			exchanges = append(exchanges, e)
+			byID[m.ID] = e
+		} else if e, ok := byID[m.ID]; ok && e.expected == nil {
+			e.expected = m
+		}
+	}
+}
+
+//replay send requests with original IDs and timing scaled by speed and wait for responces
+func replay(cl *fdstream.AsyncClient, exchanges []*exchange) {
+	var (
+		l       sync.Mutex
+		pending int
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+		byID    = make(map[uint32]*exchange, len(exchanges))
+	)
+	for _, e := range exchanges {
+		byID[e.request.ID] = e
+		if e.expected != nil {
+			pending++
+		}
+	}
+	if pending == 0 {
+		close(done)
+	}
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case m := <-cl.ToReadQ:
+				l.Lock()
+				if e, ok := byID[m.ID]; ok && e.actual == nil && e.expected != nil {
+					e.actual = m
+					if pending--; pending == 0 {
+						close(done)
+					}
+				}
+				l.Unlock()
+			case <-cl.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for _, e := range exchanges {
+		if ctx.speed > 0 {
+			offset := time.Duration(float64(e.at.Sub(exchanges[0].at)) / ctx.speed)
+			time.Sleep(time.Until(start.Add(offset)))
+		}
+		m := *e.request
+		cl.ToSendQ <- &m
+	}
+	logger.Printf("Sent %d requests in %v", len(exchanges), time.Since(start))
+
+	select {
+	case <-done:
+	case <-time.After(ctx.timeout):
+	case <-cl.Done():
+		logger.Printf("Connection closed: %v", cl.Err())
+	}
+	cl.Shutdown()
+	<-stopped //late responces are not counted
+}
+
+//diff print requests which got different or no responce and return their count
+func diff(exchanges []*exchange) int {
+	var matched, differ, missing int
+	for _, e := range exchanges {
+		if e.expected == nil {
+			continue
+		}
+		if e.actual == nil {
+			missing++
+			logger.Printf("#%d %s: no responce", e.request.ID, e.request.Name)
+			continue
+		}
+		if e.expected.Code == e.actual.Code && e.expected.Name == e.actual.Name && bytes.Equal(e.expected.Payload, e.actual.Payload) {
+			matched++
+			continue
+		}
+		differ++
+		logger.Printf("#%d %s: expected %s, got %s", e.request.ID, e.request.Name, describe(e.expected), describe(e.actual))
+	}
+	logger.Printf("Matched %d, differ %d, missing %d", matched, differ, missing)
+	return differ + missing
+}
+
+func describe(m *fdstream.Message) string {
+	payload := m.Payload
+	if len(payload) > 64 {
+		payload = payload[:64]
+	}
+	return fmt.Sprintf("code %d name %q payload(%d) %q", m.Code, m.Name, len(m.Payload), payload)
+}
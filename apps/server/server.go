@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Asuan/fdstream"
 )
@@ -24,6 +26,7 @@ var (
 	wg         sync.WaitGroup
 	logger     *log.Logger
 	cpuprofile string
+	metrics    string
 )
 
 //Initialize flags
@@ -36,6 +39,8 @@ func Initialize() {
 	flag.StringVar(&ctx.bind, "bind", "0.0.0.0:1900", "address to bind")
 	//Profile
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile `file`")
+	//Metrics
+	flag.StringVar(&metrics, "metrics", "", "serve Prometheus metrics on /metrics and expvar on /debug/vars at `address`")
 
 	flag.Parse()
 
@@ -69,12 +74,35 @@ func Initialize() {
 func main() {
 	Initialize()
 
+	var served uint64
+	srv := &fdstream.Server{
+		//Example handler for income messages
+		Handler: fdstream.HandlerFunc(func(message *fdstream.Message) *fdstream.Message {
+			i := atomic.AddUint64(&served, 1)
+			logger.Printf("Get message %s", message.Name)
+			return &fdstream.Message{
+				Name:    message.Name, //Same name for validating
+				Payload: []byte(fmt.Sprintf("Responce #%d", i)),
+			}
+		}),
+		Workers: 2,
+	}
+	defer srv.Close()
+
+	if metrics != "" {
+		fdstream.Register("server", srv) //all connections are exported as one source
+		http.Handle("/metrics", fdstream.DefaultRegistry)
+		fdstream.DefaultRegistry.Publish("fdstream")
+		go func() {
+			logger.Printf("Metrics server stopped: %v", http.ListenAndServe(metrics, nil))
+		}()
+	}
+
 	l, err := net.ListenTCP("tcp", ctx.tcpAddr)
 	if err != nil {
 		logger.Printf("Could not connect to address: %s error: %v", ctx.tcpAddr.String(), err)
 	}
 	defer l.Close()
-	var i int
 	for {
 		conn, err := l.AcceptTCP()
 		if err != nil {
@@ -86,57 +114,16 @@ func main() {
 		conn.SetWriteBuffer(fdstream.MaxMessageSize * 200)
 		conn.SetKeepAlive(true)
 
-		//Example handlers for connections
-		go HandleTCP(conn, i)
-
-		i++
+		go HandleTCP(srv, conn)
 	}
 
 	logger.Printf("Stop server server")
 }
 
-//HandleTCP handle messages
-func HandleTCP(conn *net.TCPConn, instanceNum int) error {
-	defer conn.Close()
-
+//HandleTCP serve messages of connection by server
+func HandleTCP(srv *fdstream.Server, conn *net.TCPConn) error {
 	logger.Printf("Handle connection: %s", conn.RemoteAddr().String())
-
-	var (
-		i   int
-		err error
-		wg  sync.WaitGroup
-		cl  *fdstream.AsyncClient
-	)
-	cl, err = fdstream.NewAsyncClient(conn, conn)
-	if err != nil {
-		return err
-	}
-
-	//Example worker for handling income messages
-	worker := func() {
-		var (
-			message     *fdstream.Message
-			backPayload []byte
-		)
-		for cl.IsAlive() {
-			i++
-			backPayload = []byte(fmt.Sprintf("Responce I-%d-#%d", instanceNum, i))
-			message = <-cl.ToReadQ //It is thread safe to read and write
-			logger.Printf("Get message %s", message.Name)
-			cl.ToSendQ <- &fdstream.Message{
-				ID:      message.ID,   //It is need for sync communication
-				Name:    message.Name, //Same name for validating
-				Payload: backPayload,
-			}
-		}
-		wg.Done()
-		logger.Printf("Finish serving connection %d with total messages count: %d", instanceNum, i)
-	}
-
-	wg.Add(2)
-	go worker()
-	go worker()
-	wg.Wait()
-
-	return nil
+	err := srv.ServeConn(conn)
+	logger.Printf("Finish serving connection %s with error: %v", conn.RemoteAddr().String(), err)
+	return err
 }
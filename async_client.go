@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 )
@@ -26,24 +27,131 @@ type AsyncClient struct {
 	ToSendQ      chan *Message //Queue to send to remote
 	ToReadQ      chan *Message //Queue to read message
 	killer       *sync.Once
-	kill         chan bool
+	kill         chan struct{}
 	alive        atomic.Value
+	errLock      sync.Mutex
+	err          error
+	channelsLock sync.Mutex
+	channels     map[uint16]*Channel
+	flow         *flowControl
+	sendQs       [priorityClasses]chan *Message //high, normal (ToSendQ) and low priority queues
+
+	fragmentSize    int //payload size of fragments written by writer
+	reassemblyLimit int //memory limit of incomplete messages read by reader
+
+	streamCounter uint32
+	streamsLock   sync.Mutex
+	streams       chan *Stream //incoming streams which are not accepted yet
+	inStreams     map[uint32]*Stream
+	outStreams    map[uint32]*outStream
+
+	files *fileConn //connection which pass files, it is set by WithFiles option
+	hello *handshake
+
+	principal string //authenticated name of peer
+
+	signer     *Signer
+	keyring    Keyring
+	verifyMode VerifyMode
+
+	stats   *clientStats
+	metrics MetricsSink
+	tracer  Tracer
+
+	interceptors []ClientInterceptor
+	logger       *clientLogger
+	capture      *CaptureWriter
+
+	serveChannels bool //deliver channel messages to ToReadQ instead of channel queues
 }
 
 //NewAsyncClient create async handler
-func NewAsyncClient(outcome io.Writer, income io.ReadCloser) (*AsyncClient, error) {
+func NewAsyncClient(outcome io.Writer, income io.ReadCloser, opts ...Option) (*AsyncClient, error) {
+	cfg := newConfig(opts)
+	raw := income
+	logger := newClientLogger(cfg, raw)
+	var files *fileConn
+	if cfg.files {
+		if cfg.psk != nil {
+			return nil, ErrFilesNotSupported
+		}
+		var err error
+		if files, err = newFileConn(outcome, income); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.psk != nil {
+		conn, err := newSecureConn(outcome, income, cfg.psk)
+		if err != nil {
+			return nil, err
+		}
+		outcome, income = conn, conn
+	}
+	var principal string
+	if cfg.authenticator != nil || cfg.credentials != nil {
+		var err error
+		if principal, err = authenticate(outcome, income, raw, cfg); err != nil {
+			logger.log(levelError, "authentication failed", slog.Any("error", err))
+			return nil, err
+		}
+	}
 	c := &AsyncClient{
 		OutputStream: outcome,
 		InputStream:  income,
 		ToSendQ:      make(chan *Message, defaultQSize),
 		ToReadQ:      make(chan *Message, defaultQSize),
-		kill:         make(chan bool, 1),
+		kill:         make(chan struct{}),
 		killer:       new(sync.Once),
+		channels:     make(map[uint16]*Channel),
+		streams:      make(chan *Stream, defaultQSize),
+		inStreams:    make(map[uint32]*Stream),
+		outStreams:   make(map[uint32]*outStream),
+		files:        files,
+		principal:    principal,
+		signer:       cfg.signer,
+		keyring:      cfg.keyring,
+		verifyMode:   cfg.verifyMode,
+		stats:        new(clientStats),
+		metrics:      cfg.metrics,
+		tracer:       cfg.tracer,
+		interceptors: cfg.interceptors,
+		logger:       logger,
+		capture:      cfg.capture,
+	}
+	c.sendQs = [priorityClasses]chan *Message{
+		make(chan *Message, defaultQSize),
+		c.ToSendQ,
+		make(chan *Message, defaultQSize),
 	}
 	c.alive.Store(true)
+	c.serveChannels = cfg.serveChannels
+	c.fragmentSize, c.reassemblyLimit = cfg.fragmentSize, cfg.reassemblyLimit
+	c.hello = newHandshake(cfg)
+	if cfg.flowMessages > 0 {
+		//read queue is unbuffered so every received message is really read by consumer
+		c.ToReadQ = make(chan *Message)
+		c.flow = newFlowControl(cfg.flowMessages, cfg.flowBytes)
+		go c.workerPump(c.flow)
+	}
+
+	if principal != "" {
+		c.logger = c.logger.with(slog.String("principal", principal))
+	}
+	c.logger.log(levelConnection, "connection open")
 
 	go c.workerReader(c.ToReadQ)
-	go c.workerWriter(c.ToSendQ)
+	if cfg.helloTimeout > 0 {
+		go func() {
+			c.hello.start(c) //hello is the first frame of connection
+			select {
+			case <-c.hello.done: //frames of legacy peer depend on answer
+			case <-c.kill:
+			}
+			c.workerWriter()
+		}()
+	} else {
+		go c.workerWriter()
+	}
 	return c, nil
 }
 
@@ -51,88 +159,191 @@ func NewAsyncClient(outcome io.Writer, income io.ReadCloser) (*AsyncClient, erro
 func (c *AsyncClient) workerReader(outcome chan<- *Message) {
 	var (
 		err    error
-		n      int
-		lenB   int
-		lenP   uint16
-		cursor uint16
-		id     uint32
-		code   byte
-		eof    bool
+		m      *Message
 		header = make([]byte, messageHeaderSize, messageHeaderSize)
-		reader = bufio.NewReaderSize(c.InputStream, MaxMessageSize*5)
+		parts  = newReassembler(c.reassemblyLimit)
+		input  io.Reader
 	)
+	input = c.InputStream
+	if c.files != nil {
+		input = c.files
+		defer c.files.close()
+	}
+	reader := bufio.NewReaderSize(input, MaxMessageSize*5)
 
-	for !eof {
-		n, err = reader.Read(header)
-		if err != nil {
+	for {
+		if m, err = readMessage(reader, header); err != nil {
 			break //If we get error so looks like no way to continue
 		}
-		if n == 0 { //Skip empty read
-			continue
-		}
-
-		if n != messageHeaderSize { //Wrong message header read looks broken state
-			break
+		c.received(m)
+		if len(m.Files) > 0 {
+			if err = c.receiveFiles(m); err != nil {
+				break
+			}
 		}
-		//TODO optimize reading
-		code, id, cursor, lenP = unmarshalHeader(header)
-		m := &Message{
-			Code: code,
-			ID:   id,
-
-			//This is so slow operation but we should copy data from messageBody buffer
-			Payload: make([]byte, lenP, lenP),
+		if m.fragment&flagStream != 0 {
+			if err = c.verify(m); err != nil {
+				break
+			}
+			if err = c.streamFrame(m); err != nil {
+				break
+			}
+			continue
 		}
-
-		name := make([]byte, cursor, cursor)
-		lenB, err = reader.Read(name)
-		if err != nil {
-			//try read last message if EOF appear
-			if err != io.EOF || lenB != int(cursor+lenP) {
+		if m.fragment != 0 {
+			if m, err = parts.add(m); err != nil {
 				break
 			}
-			eof = true
+			if m == nil {
+				continue
+			}
 		}
-
-		if cursor > 0 {
-			m.Name = dirtyString(name) //avoid data copy
+		if err = c.verify(m); err != nil {
+			break
 		}
-
-		if lenP > 0 {
-			reader.Read(m.Payload)
+		if c.dispatch(m) {
+			continue
 		}
-
 		outcome <- m
 	}
-	c.Shutdown()
+	if err != io.EOF && c.IsAlive() {
+		c.logger.log(levelError, "read failed", slog.Any("error", err))
+	}
+	c.fail(err)
 }
 
-//Write message by message to output reader from chan it can be run in multiple instances
-func (c *AsyncClient) workerWriter(income <-chan *Message) {
+//Write message by message to output reader from send queues it can be run in multiple instances
+// big messages are split to fragments which are interleaved with other messages
+func (c *AsyncClient) workerWriter() {
 	var (
-		err error
-		m   *Message
+		err     error
+		m       *Message
+		ok      bool
+		skipped [priorityClasses]int
+		frag    = newFragmenter(c.fragmentSize)
+		parked  []*Message      //messages of default channel waiting for credits of peer
+		granted <-chan struct{} //closed when peer grant credits for first parked message
 		//buf = bufio.NewWriterSize(c.OutputStream, MaxMessageSize*5)
 		//i int
 	)
-mainLoop:
+loop:
 	for {
-		select {
-		case <-c.kill:
-			break mainLoop
-		case m = <-income:
-			if _, err = m.WriteTo(c.OutputStream); err != nil {
-				break mainLoop
+		for len(parked) > 0 {
+			if ok, granted = c.flow.tryAcquire(parked[0]); !ok {
+				break
+			}
+			if err = c.put(frag, parked[0]); err != nil {
+				break loop
+			}
+			parked[0], parked = nil, parked[1:]
+		}
+
+		m = nil
+		if len(parked) < defaultQSize {
+			if m, ok = c.nextMessage(&skipped, !frag.active(), granted); !ok {
+				break
+			}
+		} else if !frag.active() { //too many parked messages, other queues wait for credits too
+			select {
+			case <-granted:
+			case <-c.kill:
+				break loop
+			}
+		}
+		if m != nil {
+			c.sign(m)
+			if c.limited(m) && len(parked) > 0 {
+				parked = append(parked, m) //keep order of default channel
+			} else if c.limited(m) {
+				if ok, granted = c.flow.tryAcquire(m); !ok {
+					parked = append(parked, m)
+				} else if err = c.put(frag, m); err != nil {
+					break
+				}
+			} else if err = c.put(frag, m); err != nil {
+				break
+			}
+		}
+		if frag.active() {
+			m = frag.fragment()
+			if err = c.writeMessage(m); err != nil && c.writeFailed(m, err) {
+				break
 			}
 		}
 	}
 	c.Shutdown()
 }
 
+//put write message or start its fragmentation, messages are not fragmented for peer without fragments
+func (c *AsyncClient) put(frag *fragmenter, m *Message) error {
+	if c.allows(FeatureFragments) && frag.takes(m) {
+		frag.add(m)
+		return nil
+	}
+	if err := c.writeMessage(m); err != nil && c.writeFailed(m, err) {
+		return err
+	}
+	return nil
+}
+
 //Write will write message to destination
-//The function is thread safe
+//The function is thread safe, big message, message waiting for credits of flow control
+// or end of handshake are sent by writer
 func (c *AsyncClient) Write(m *Message) {
-	m.WriteTo(c.OutputStream)
+	c.sign(m)
+	if err := c.check(m); err != nil {
+		c.logger.log(levelDrop, "message dropped", messageAttrs(m, slog.Any("error", err))...)
+		return
+	}
+	if len(m.Payload) > c.fragmentSize || c.limited(m) || c.negotiating() {
+		c.Send(m)
+		return
+	}
+	if err := c.writeMessage(m); err != nil {
+		c.logger.log(levelDrop, "message dropped", messageAttrs(m, slog.Any("error", err))...)
+	}
+}
+
+//writeFailed report error of writer, invalid message is dropped while other errors shutdown client,
+// it return true when client is failed
+func (c *AsyncClient) writeFailed(m *Message, err error) bool {
+	if invalidMessage(err) {
+		c.logger.log(levelDrop, "message dropped", messageAttrs(m, slog.Any("error", err))...)
+		return false
+	}
+	if c.IsAlive() {
+		c.logger.log(levelError, "write failed", messageAttrs(m, slog.Any("error", err))...)
+	}
+	c.fail(err)
+	return true
+}
+
+//invalidMessage check that error is caused by message itself and not by connection
+func invalidMessage(err error) bool {
+	switch err {
+	case ErrNameTooLong, ErrPayloadTooLong, ErrTooManyFiles, ErrKeyIDTooLong, ErrSignatureLength,
+		ErrMetadataTooLong, ErrFilesNotSupported, ErrNotNegotiated:
+		return true
+	}
+	return false
+}
+
+//check validate message before it is queued, big payload is valid because it is fragmented by writer
+func (c *AsyncClient) check(m *Message) error {
+	if len(m.Files) > 0 && c.files == nil {
+		return ErrFilesNotSupported
+	}
+	return m.validate()
+}
+
+//writeControl write control message directly, it is never blocked by flow control
+func (c *AsyncClient) writeControl(m *Message) {
+	if c.legacyPeer() {
+		return //legacy peer does not know control messages
+	}
+	if err := c.writeFrame(m); err == nil {
+		c.sent(m)
+	}
 }
 
 //WriteNamed will write marshalable object to destination
@@ -162,11 +373,45 @@ func (c *AsyncClient) Read() *Message {
 //Shutdown close  read but save un-readed or un-writhed data.
 func (c *AsyncClient) Shutdown() {
 	//DO not close chans need grace safe in-progress messages
+	c.alive.Store(false)
 	c.killer.Do(func() {
 		close(c.kill)         //It should stop writer
 		c.InputStream.Close() //We should notify all 3d writes about trouble.
+		if c.logger != nil {
+			c.errLock.Lock()
+			err := c.err
+			c.errLock.Unlock()
+			c.logger.log(levelConnection, "connection closed", slog.Any("error", err))
+		}
 	})
-	c.alive.Store(false)
+}
+
+//fail shutdown client with reason, only first reason is kept
+func (c *AsyncClient) fail(err error) {
+	c.errLock.Lock()
+	if c.err == nil && c.IsAlive() {
+		c.err = err
+	}
+	c.errLock.Unlock()
+	c.Shutdown()
+}
+
+//Err return reason of client shutdown or nil if client is alive
+func (c *AsyncClient) Err() error {
+	if c.IsAlive() {
+		return nil
+	}
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	if c.err == nil {
+		return ErrConnectionClosed
+	}
+	return c.err
+}
+
+//Done return chan which is closed when client is shut down
+func (c *AsyncClient) Done() <-chan struct{} {
+	return c.kill
 }
 
 //IsAlive notify about state of async client
@@ -2,8 +2,11 @@ package fdstream
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -107,7 +110,7 @@ func TestWrite(t *testing.T) {
 
 func TestRead(t *testing.T) {
 	as := assert.New(t)
-	data, _ := (&Message{"name", 0, byte(0), []byte("anry")}).Marshal()
+	data, _ := (&Message{Name: "name", Code: byte(0), Payload: []byte("anry")}).Marshal()
 	readCloser := &TestReaderWaiter{
 		data: data,
 		d:    time.Duration(200 * time.Millisecond), //Wait reader for test writer
@@ -127,3 +130,24 @@ func TestRead(t *testing.T) {
 
 	handler.Shutdown()
 }
+
+func TestInvalidMessage(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	//invalid messages are returned to caller or dropped, connection stay alive
+	as.Equal(ErrFilesNotSupported, a.Send(&Message{Name: "files", Files: []*os.File{os.Stdin}}))
+	as.Equal(ErrSignatureLength, a.Send(&Message{Name: "signed", Signature: []byte("short")}))
+	sc := newSyncClient(a, a.ToReadQ, time.Second)
+	err := sc.enqueue(context.Background(), &Message{Name: "meta", Metadata: map[string]string{"k": strings.Repeat("v", 1<<16)}})
+	as.Equal(ErrMetadataTooLong, err)
+	a.Write(&Message{Name: strings.Repeat("n", maxNameLen+1)})
+	a.ToSendQ <- &Message{Name: "queued", Files: []*os.File{os.Stdin}}
+	a.ToSendQ <- &Message{Name: "valid"}
+
+	as.Equal("valid", b.Read().Name)
+	as.True(a.IsAlive())
+	as.Nil(a.Err())
+}
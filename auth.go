@@ -0,0 +1,216 @@
+package fdstream
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	//ctrlAuthCode is a code of authentication frames exchanged before normal traffic,
+	// server send challenge, client answer credentials and server confirm principal in name
+	ctrlAuthCode       byte = 247
+	erUnauthorizedCode byte = 246
+	//CodeUnauthorized is a code of frame which reject peer without valid credentials
+	CodeUnauthorized = erUnauthorizedCode
+
+	//authTimeout limit exchange of authentication frames when connection support deadlines
+	authTimeout   = 10 * time.Second
+	challengeSize = 32
+)
+
+var (
+	//ErrUnauthorized mean credentials were rejected
+	ErrUnauthorized = errors.New("Unauthorized")
+	//ErrAuthProtocol mean peer send unexpected frame during authentication
+	ErrAuthProtocol = errors.New("Unexpected authentication frame")
+)
+
+//Authenticator verify credentials of connecting peer on server side
+type Authenticator interface {
+	//Challenge return data sent to client before it send credentials, it can be empty
+	Challenge() ([]byte, error)
+	//Verify check credentials for challenge and return principal of peer
+	Verify(challenge, credentials []byte) (principal string, err error)
+}
+
+//Credentials produce credentials of client for challenge of server
+type Credentials interface {
+	Credentials(challenge []byte) ([]byte, error)
+}
+
+//WithAuthenticator make client verify peer credentials before normal traffic, it is used by server side
+func WithAuthenticator(a Authenticator) Option {
+	return func(cfg *config) {
+		cfg.authenticator = a
+	}
+}
+
+//WithCredentials make client authenticate to server before normal traffic
+func WithCredentials(c Credentials) Option {
+	return func(cfg *config) {
+		cfg.credentials = c
+	}
+}
+
+type principalKey struct{}
+
+//PrincipalFromContext return authenticated principal of connection which message came from
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalKey{}).(string)
+	return p, ok
+}
+
+//Principal return authenticated name of peer, it is empty without authentication
+func (c *AsyncClient) Principal() string {
+	return c.principal
+}
+
+//deadliner is a connection which support deadlines
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+//authenticate exchange authentication frames before workers of client are started
+func authenticate(w io.Writer, r io.Reader, deadline interface{}, cfg config) (string, error) {
+	if d, ok := deadline.(deadliner); ok {
+		d.SetDeadline(time.Now().Add(authTimeout))
+		defer d.SetDeadline(time.Time{})
+	}
+	header := make([]byte, messageHeaderSize)
+	if cfg.authenticator != nil {
+		return verifyPeer(w, r, header, cfg.authenticator)
+	}
+	return login(w, r, header, cfg.credentials)
+}
+
+//verifyPeer is a server side of authentication
+func verifyPeer(w io.Writer, r io.Reader, header []byte, a Authenticator) (string, error) {
+	challenge, err := a.Challenge()
+	if err != nil {
+		return "", err
+	}
+	if _, err = (&Message{Code: ctrlAuthCode, Payload: challenge}).WriteTo(w); err != nil {
+		return "", err
+	}
+	m, err := readMessage(r, header)
+	if err != nil {
+		return "", err
+	}
+	if m.Code != ctrlAuthCode {
+		err = ErrAuthProtocol
+	}
+	var principal string
+	if err == nil {
+		principal, err = a.Verify(challenge, m.Payload)
+	}
+	if err != nil {
+		(&Message{Code: CodeUnauthorized, Name: err.Error()}).WriteTo(w)
+		return "", err
+	}
+	_, err = (&Message{Code: ctrlAuthCode, Name: principal}).WriteTo(w)
+	return principal, err
+}
+
+//login is a client side of authentication, it return principal confirmed by server
+func login(w io.Writer, r io.Reader, header []byte, c Credentials) (string, error) {
+	m, err := readMessage(r, header)
+	if err != nil {
+		return "", err
+	}
+	if m.Code != ctrlAuthCode {
+		return "", ErrAuthProtocol
+	}
+	credentials, err := c.Credentials(m.Payload)
+	if err != nil {
+		return "", err
+	}
+	if _, err = (&Message{Code: ctrlAuthCode, Payload: credentials}).WriteTo(w); err != nil {
+		return "", err
+	}
+	if m, err = readMessage(r, header); err != nil {
+		return "", err
+	}
+	switch m.Code {
+	case ctrlAuthCode:
+		return m.Name, nil
+	case CodeUnauthorized:
+		return "", ErrUnauthorized
+	}
+	return "", ErrAuthProtocol
+}
+
+//TokenAuthenticator accept static tokens, tokens map token to principal
+type TokenAuthenticator map[string]string
+
+//Challenge is empty for static tokens
+func (a TokenAuthenticator) Challenge() ([]byte, error) {
+	return nil, nil
+}
+
+//Verify find principal of token, comparison take same time for every known token
+func (a TokenAuthenticator) Verify(challenge, credentials []byte) (string, error) {
+	var principal string
+	for token, p := range a {
+		if subtle.ConstantTimeCompare([]byte(token), credentials) == 1 {
+			principal = p
+		}
+	}
+	if principal == "" {
+		return "", ErrUnauthorized
+	}
+	return principal, nil
+}
+
+//TokenCredentials send static token
+type TokenCredentials string
+
+//Credentials return token
+func (t TokenCredentials) Credentials(challenge []byte) ([]byte, error) {
+	return []byte(t), nil
+}
+
+//HMACAuthenticator verify HMAC-SHA256 of random challenge, keys map principal to its secret key
+type HMACAuthenticator map[string][]byte
+
+//Challenge return random bytes
+func (a HMACAuthenticator) Challenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+//Verify check HMAC of challenge by key of principal, credentials are [mac, principal]
+func (a HMACAuthenticator) Verify(challenge, credentials []byte) (string, error) {
+	if len(credentials) <= sha256.Size {
+		return "", ErrUnauthorized
+	}
+	principal := string(credentials[sha256.Size:])
+	key, ok := a[principal]
+	if !ok || !hmac.Equal(credentials[:sha256.Size], challengeMAC(key, challenge)) {
+		return "", ErrUnauthorized
+	}
+	return principal, nil
+}
+
+//HMACCredentials sign challenge of server by key of principal
+type HMACCredentials struct {
+	Principal string
+	Key       []byte
+}
+
+//Credentials return HMAC of challenge followed by principal
+func (c HMACCredentials) Credentials(challenge []byte) ([]byte, error) {
+	return append(challengeMAC(c.Key, challenge), c.Principal...), nil
+}
+
+func challengeMAC(key, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package fdstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func whoAmIHandler(m *Message) *Message {
+	principal, _ := PrincipalFromContext(m.Context())
+	return &Message{Name: m.Name, Payload: []byte(principal)}
+}
+
+//serveAuth start server connection and return client side and result of ServeConn
+func serveAuth(a Authenticator) (net.Conn, chan error) {
+	client, server := net.Pipe()
+	s := &Server{Handler: HandlerFunc(whoAmIHandler), Authenticator: a}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(server) }()
+	return client, done
+}
+
+func TestAuthToken(t *testing.T) {
+	as := assert.New(t)
+	tokens := TokenAuthenticator{"alice-token": "alice", "bob-token": "bob"}
+
+	conn, _ := serveAuth(tokens)
+	c, err := NewSyncClient(conn, conn, time.Second, WithCredentials(TokenCredentials("bob-token")))
+	as.Nil(err)
+	defer c.Shutdown()
+	as.Equal("bob", c.Principal())
+	m, err := c.WriteAndReadResponce(&Message{Name: "who"})
+	as.Nil(err)
+	as.Equal([]byte("bob"), m.Payload)
+
+	conn, done := serveAuth(tokens)
+	_, err = NewSyncClient(conn, conn, time.Second, WithCredentials(TokenCredentials("wrong")))
+	as.Equal(ErrUnauthorized, err)
+	as.Equal(ErrUnauthorized, <-done)
+}
+
+func TestAuthHMAC(t *testing.T) {
+	as := assert.New(t)
+	keys := HMACAuthenticator{"worker": []byte("worker key")}
+
+	conn, _ := serveAuth(keys)
+	c, err := NewSyncClient(conn, conn, time.Second, WithCredentials(HMACCredentials{Principal: "worker", Key: []byte("worker key")}))
+	as.Nil(err)
+	defer c.Shutdown()
+	m, err := c.WriteAndReadResponce(&Message{Name: "who"})
+	as.Nil(err)
+	as.Equal([]byte("worker"), m.Payload)
+
+	conn, done := serveAuth(keys)
+	_, err = NewSyncClient(conn, conn, time.Second, WithCredentials(HMACCredentials{Principal: "worker", Key: []byte("guess")}))
+	as.Equal(ErrUnauthorized, err)
+	as.Equal(ErrUnauthorized, <-done)
+
+	_, err = keys.Verify([]byte("challenge"), []byte("short"))
+	as.Equal(ErrUnauthorized, err)
+}
+
+func TestAuthMissingCredentials(t *testing.T) {
+	as := assert.New(t)
+	conn, done := serveAuth(TokenAuthenticator{"token": "user"})
+	c, err := NewSyncClient(conn, conn, time.Second)
+	as.Nil(err)
+	defer c.Shutdown()
+
+	_, err = c.WriteAndReadResponce(&Message{Name: "anonymous"})
+	as.NotNil(err)
+	as.Equal(ErrAuthProtocol, <-done)
+}
+
+func TestAuthReconnectingClient(t *testing.T) {
+	as := assert.New(t)
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		conn, _ := serveAuth(TokenAuthenticator{"token": "pooled"})
+		return conn, nil
+	}
+	c := NewReconnectingClient(dial, time.Second, ReconnectPolicy{
+		BufferCalls: true,
+		Options:     []Option{WithCredentials(TokenCredentials("token"))},
+	})
+	defer c.Close()
+	m, err := c.WriteAndReadResponce(&Message{Name: "who"})
+	as.Nil(err)
+	as.Equal([]byte("pooled"), m.Payload)
+}
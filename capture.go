@@ -0,0 +1,169 @@
+package fdstream
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+//captureMagic start every capture file
+const captureMagic = "FDSCAP1\n"
+
+//Direction of captured frame
+type Direction byte
+
+//Directions of frames
+const (
+	DirectionIn  Direction = 'I'
+	DirectionOut Direction = 'O'
+)
+
+const (
+	//maxExtendedLen is a biggest extended header: flags, channel, files count, metadata length and metadata block
+	maxExtendedLen = 1 + 2 + 1 + 2 + 1<<16 - 1
+	//maxTrailerLen is a biggest signature trailer
+	maxTrailerLen = 1 + maxKeyIDLen + sha256.Size
+	//maxCapturedFrame limit length of record so broken capture does not allocate huge frame
+	maxCapturedFrame = MaxMessageSize + maxExtendedLen + maxTrailerLen
+)
+
+//ErrCaptureFormat mean file is not a capture or it is broken
+var ErrCaptureFormat = errors.New("Invalid capture format")
+
+//CaptureRecord is a frame with time and direction
+type CaptureRecord struct {
+	Time      time.Time
+	Direction Direction
+	Message   *Message
+}
+
+//CaptureWriter record frames of clients, records are [time uint64 nanoseconds, direction, length uint32, frame],
+// it is safe to use same writer for many clients
+type CaptureWriter struct {
+	l   sync.Mutex
+	w   io.Writer
+	err error
+}
+
+//NewCaptureWriter write capture header to w and return writer of records
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+//WithCapture record every read and written frame of client including control frames
+func WithCapture(cw *CaptureWriter) Option {
+	return func(cfg *config) {
+		cfg.capture = cw
+	}
+}
+
+//record write frame, first error stop recording and it is returned by Err
+func (cw *CaptureWriter) record(dir Direction, m *Message) {
+	buf := bytes.NewBuffer(make([]byte, 13, 13+m.Len()))
+	if m.marshalTo(buf) != nil {
+		return
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint64(b[0:8], uint64(time.Now().UnixNano()))
+	b[8] = byte(dir)
+	binary.BigEndian.PutUint32(b[9:13], uint32(len(b)-13))
+
+	cw.l.Lock()
+	defer cw.l.Unlock()
+	if cw.err == nil {
+		_, cw.err = cw.w.Write(b)
+	}
+}
+
+//Err return error of underlying writer
+func (cw *CaptureWriter) Err() error {
+	cw.l.Lock()
+	defer cw.l.Unlock()
+	return cw.err
+}
+
+//CaptureReader read records of capture
+type CaptureReader struct {
+	r      *bufio.Reader
+	header []byte
+	parts  map[Direction]*reassembler
+}
+
+//NewCaptureReader check capture header and return reader of records
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{
+		r:      bufio.NewReader(r),
+		header: make([]byte, 13),
+		parts:  make(map[Direction]*reassembler),
+	}
+	if _, err := io.ReadFull(cr.r, cr.header[:len(captureMagic)]); err != nil || string(cr.header[:len(captureMagic)]) != captureMagic {
+		return nil, ErrCaptureFormat
+	}
+	return cr, nil
+}
+
+//Next return next frame, it return io.EOF at the end of capture
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	if _, err := io.ReadFull(cr.r, cr.header[:13]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCaptureFormat
+		}
+		return nil, err
+	}
+	rec := &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(cr.header[0:8]))),
+		Direction: Direction(cr.header[8]),
+	}
+	n := binary.BigEndian.Uint32(cr.header[9:13])
+	if n < messageHeaderSize || n > maxCapturedFrame {
+		return nil, ErrCaptureFormat
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, frame); err != nil {
+		return nil, ErrCaptureFormat
+	}
+	m, err := unmarshal(frame)
+	if err != nil {
+		return nil, ErrCaptureFormat
+	}
+	rec.Message = &m
+	return rec, nil
+}
+
+//NextMessage return next complete message, fragments are reassembled and control and stream frames are skipped,
+// time of message is a time of its last fragment
+func (cr *CaptureReader) NextMessage() (*CaptureRecord, error) {
+	for {
+		rec, err := cr.Next()
+		if err != nil {
+			return nil, err
+		}
+		m := rec.Message
+		if isControl(m) || m.fragment&flagStream != 0 {
+			continue
+		}
+		if m.fragment != 0 {
+			parts, ok := cr.parts[rec.Direction]
+			if !ok {
+				parts = newReassembler(defaultReassemblyLimit)
+				cr.parts[rec.Direction] = parts
+			}
+			if m, err = parts.add(m); err != nil {
+				return nil, ErrCaptureFormat
+			}
+			if m == nil {
+				continue
+			}
+			rec.Message = m
+		}
+		return rec, nil
+	}
+}
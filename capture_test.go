@@ -0,0 +1,135 @@
+package fdstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	as := assert.New(t)
+	var buf bytes.Buffer
+	cw, err := NewCaptureWriter(&buf)
+	as.Nil(err)
+
+	a, b, err := SyncPipe(time.Second)
+	as.Nil(err)
+	defer b.Shutdown()
+	a.AsyncClient.capture = cw
+	go echoPeer(b)
+
+	start := time.Now()
+	_, err = a.Call(context.Background(), &Message{Name: "small", Payload: []byte("x"), Metadata: map[string]string{"k": "v"}})
+	as.Nil(err)
+	_, err = a.Call(context.Background(), &Message{Name: "big", Payload: make([]byte, 3*defaultFragmentSize)})
+	as.Nil(err)
+	a.Shutdown()
+	as.Nil(cw.Err())
+
+	cr, err := NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	as.Nil(err)
+	var frames int
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		as.Nil(err)
+		as.False(rec.Time.Before(start))
+		frames++
+	}
+	as.Equal(8, frames) //small and big of 3 fragments in both directions
+
+	cr, err = NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	as.Nil(err)
+	var got []*CaptureRecord
+	for {
+		rec, err := cr.NextMessage()
+		if err == io.EOF {
+			break
+		}
+		as.Nil(err)
+		got = append(got, rec)
+	}
+	as.Len(got, 4)
+	as.Equal(DirectionOut, got[0].Direction)
+	as.Equal("small", got[0].Message.Name)
+	as.Equal("v", got[0].Message.Metadata["k"])
+	as.Equal(DirectionIn, got[1].Direction)
+	as.Equal(got[0].Message.ID, got[1].Message.ID)
+	as.Equal("big", got[2].Message.Name)
+	as.Len(got[2].Message.Payload, 3*defaultFragmentSize)
+	as.Len(got[3].Message.Payload, 3*defaultFragmentSize)
+}
+
+func TestCaptureFormat(t *testing.T) {
+	as := assert.New(t)
+	_, err := NewCaptureReader(bytes.NewReader([]byte("garbage")))
+	as.Equal(ErrCaptureFormat, err)
+
+	var buf bytes.Buffer
+	cw, err := NewCaptureWriter(&buf)
+	as.Nil(err)
+	cw.record(DirectionIn, &Message{Name: "n"})
+	cr, err := NewCaptureReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	as.Nil(err)
+	_, err = cr.Next()
+	as.Equal(ErrCaptureFormat, err)
+
+	//length of record is bounded by biggest frame
+	huge := append([]byte(captureMagic), make([]byte, 13)...)
+	binary.BigEndian.PutUint32(huge[len(huge)-4:], 1<<31)
+	cr, err = NewCaptureReader(bytes.NewReader(huge))
+	as.Nil(err)
+	_, err = cr.Next()
+	as.Equal(ErrCaptureFormat, err)
+
+	//biggest signed frame with metadata is read
+	buf.Reset()
+	cw, _ = NewCaptureWriter(&buf)
+	big := &Message{Name: strings.Repeat("n", maxNameLen), Payload: make([]byte, maxPayloadLen),
+		Metadata: map[string]string{"k": strings.Repeat("v", 60000)}}
+	Signer{KeyID: strings.Repeat("k", maxKeyIDLen), Key: []byte("key")}.Sign(big)
+	cw.record(DirectionOut, big)
+	cr, _ = NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	rec, err := cr.Next()
+	as.Nil(err)
+	as.Len(rec.Message.Payload, maxPayloadLen)
+}
+
+func TestWithCapture(t *testing.T) {
+	as := assert.New(t)
+	rec := new(logRecorder) //writer with lock
+	cw, err := NewCaptureWriter(rec)
+	as.Nil(err)
+	a, b, err := Pipe(WithClientOptions(WithCapture(cw)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	a.ToSendQ <- &Message{Name: "n"}
+	<-b.ToReadQ
+
+	as.True(waitFor(func() bool {
+		rec.l.Lock()
+		defer rec.l.Unlock()
+		cr, err := NewCaptureReader(bytes.NewReader(rec.buf.Bytes()))
+		if err != nil {
+			return false
+		}
+		var directions []Direction
+		for {
+			r, err := cr.Next()
+			if err != nil {
+				break
+			}
+			directions = append(directions, r.Direction)
+		}
+		return len(directions) == 2 && directions[0] == DirectionOut && directions[1] == DirectionIn
+	}))
+}
@@ -0,0 +1,247 @@
+package fdstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//channelWindow is a count of messages peer can send to channel before it get credit back
+	channelWindow = defaultQSize
+	//ctrlCreditCode is a code of control message which grant credits,
+	// payload is uint32 count of messages and uint32 count of bytes
+	ctrlCreditCode byte = 250
+)
+
+//ErrWindowExceeded mean peer send more messages to channel or connection than it was granted
+var ErrWindowExceeded = errors.New("Peer exceeded channel window")
+
+//credit is a counting semaphore of messages or bytes peer is ready to receive
+type credit struct {
+	l        sync.Mutex
+	n        int64
+	capacity int64
+	notify   chan struct{} //closed when credit is added
+}
+
+func newCredit(n int64) *credit {
+	return &credit{n: n, capacity: n, notify: make(chan struct{})}
+}
+
+//acquire take n credits or wait until they are granted
+// request bigger than capacity is allowed when all credits are free
+func (c *credit) acquire(ctx context.Context, done <-chan struct{}, n int64) error {
+	for {
+		c.l.Lock()
+		if c.n >= n || c.n >= c.capacity {
+			c.n -= n
+			c.l.Unlock()
+			return nil
+		}
+		wait := c.notify
+		c.l.Unlock()
+
+		select {
+		case <-wait:
+		case <-done:
+			return ErrConnectionClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//tryAcquire take n credits without waiting, on failure it return chan which is closed when credit is added
+func (c *credit) tryAcquire(n int64) (bool, <-chan struct{}) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.n >= n || c.n >= c.capacity {
+		c.n -= n
+		return true, nil
+	}
+	return false, c.notify
+}
+
+func (c *credit) add(n int64) {
+	c.l.Lock()
+	c.n += n
+	close(c.notify)
+	c.notify = make(chan struct{})
+	c.l.Unlock()
+}
+
+//Channel is a logical channel over AsyncClient connection with own read queue and flow control
+// peer can send only window of messages to channel until they are read, so slow channel does not block others
+type Channel struct {
+	client   *AsyncClient
+	n        uint16
+	queue    chan *Message
+	window   *credit //messages peer is ready to receive
+	consumed int32   //messages read since last grant
+
+	syncOnce   sync.Once
+	syncClient *SyncClient
+}
+
+func newChannel(c *AsyncClient, n uint16) *Channel {
+	return &Channel{
+		client: c,
+		n:      n,
+		queue:  make(chan *Message, channelWindow),
+		window: newCredit(channelWindow),
+	}
+}
+
+//serveChannels deliver messages of all channels to ToReadQ, it is used by Server
+func serveChannels() Option {
+	return func(cfg *config) {
+		cfg.serveChannels = true
+	}
+}
+
+//Channel return logical channel with number n, it is created on first use by any side
+// n should be positive, channel 0 is a default stream of client and nil is returned for it
+func (c *AsyncClient) Channel(n uint16) *Channel {
+	if n == 0 {
+		return nil
+	}
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+	ch, ok := c.channels[n]
+	if !ok {
+		ch = newChannel(c, n)
+		c.channels[n] = ch
+	}
+	return ch
+}
+
+//dispatch route control and channel messages, it return false for messages which should go to ToReadQ
+// control codes of features which are not used are delivered as usual messages
+func (c *AsyncClient) dispatch(m *Message) bool {
+	switch {
+	case m.Code == ctrlCreditCode && (m.Channel != 0 || c.flow != nil): //credits of default channel are sent only with flow control
+		c.grant(m)
+		return true
+	case m.Code == ctrlHelloCode && c.hello.timeout > 0:
+		c.receiveHello(m)
+		return true
+	}
+	if m.Channel == 0 {
+		return c.flow != nil && c.flow.deliver(c, m)
+	}
+	if c.serveChannels {
+		return false //server read all channels from ToReadQ and release them after handling
+	}
+	ch := c.Channel(m.Channel)
+	select {
+	case ch.queue <- m:
+	default:
+		c.fail(ErrWindowExceeded)
+	}
+	return true
+}
+
+//grant add credits from control message to channel or connection window
+func (c *AsyncClient) grant(m *Message) {
+	if len(m.Payload) < 4 {
+		return
+	}
+	messages := int64(binary.BigEndian.Uint32(m.Payload))
+	if m.Channel != 0 {
+		c.Channel(m.Channel).window.add(messages)
+		return
+	}
+	if c.flow == nil {
+		return
+	}
+	c.flow.messages.add(messages)
+	if c.flow.bytes != nil && len(m.Payload) >= 8 {
+		c.flow.bytes.add(int64(binary.BigEndian.Uint32(m.Payload[4:8])))
+	}
+}
+
+//newCreditMessage create control message which grant credits to peer
+func newCreditMessage(channel uint16, messages, bytes uint32) *Message {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint32(payload[0:4], messages)
+	binary.BigEndian.PutUint32(payload[4:8], bytes)
+	return &Message{Code: ctrlCreditCode, Channel: channel, Payload: payload}
+}
+
+//Number return channel number
+func (ch *Channel) Number() uint16 {
+	return ch.n
+}
+
+//Read wait next message of channel, nil is returned when connection is closed
+func (ch *Channel) Read() *Message {
+	select {
+	case m := <-ch.queue:
+		ch.release()
+		return m
+	case <-ch.client.Done():
+		return nil
+	}
+}
+
+//release count read message and grant credits back to peer by half of window
+func (ch *Channel) release() {
+	const grant = channelWindow / 2
+	if atomic.AddInt32(&ch.consumed, 1) != grant {
+		return
+	}
+	atomic.AddInt32(&ch.consumed, -grant)
+	ch.client.writeControl(newCreditMessage(ch.n, grant, 0))
+}
+
+//Write send message to channel, it wait while peer window is exhausted
+func (ch *Channel) Write(m *Message) error {
+	return ch.send(context.Background(), m)
+}
+
+//WriteContext send message to channel, it wait while peer window is exhausted or ctx is done
+func (ch *Channel) WriteContext(ctx context.Context, m *Message) error {
+	return ch.send(ctx, m)
+}
+
+func (ch *Channel) send(ctx context.Context, m *Message) error {
+	if m == nil {
+		return errNilMessage
+	}
+	if err := ch.client.check(m); err != nil {
+		return err
+	}
+	if err := ch.client.negotiate(FeatureChannels); err != nil {
+		return err
+	}
+	done := ch.client.Done()
+	if err := ch.window.acquire(ctx, done, 1); err != nil {
+		return err
+	}
+	m.Channel = ch.n
+	select {
+	case ch.client.queue(m.Priority) <- m:
+		return nil
+	case <-done:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		ch.window.add(1)
+		return ctx.Err()
+	}
+}
+
+//SyncClient return client with sync calls over the channel, it is created once on first call
+// the channel should not be read by Read when sync client is used
+func (ch *Channel) SyncClient(timeout time.Duration) *SyncClient {
+	ch.syncOnce.Do(func() {
+		ch.syncClient = newSyncClient(ch.client, ch.queue, timeout)
+		ch.syncClient.received = ch.release
+		ch.syncClient.write = ch.send
+		go ch.syncClient.synchronizationWorker()
+	})
+	return ch.syncClient
+}
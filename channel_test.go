@@ -0,0 +1,95 @@
+package fdstream
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newPeers connect two clients by net.Pipe, every side get own options
+func newPeers(t *testing.T, optsA, optsB []Option) (*AsyncClient, *AsyncClient) {
+	left, right := net.Pipe()
+	a, err := NewAsyncClient(left, left, optsA...)
+	assert.Nil(t, err)
+	b, err := NewAsyncClient(right, right, optsB...)
+	assert.Nil(t, err)
+	return a, b
+}
+
+func TestChannelReadWrite(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	as.Nil(a.Channel(0))
+	as.Nil(a.Channel(1).Write(&Message{Name: "channel", Payload: []byte("1")}))
+	a.ToSendQ <- &Message{Name: "default"}
+
+	m := b.Channel(1).Read()
+	as.Equal("channel", m.Name)
+	as.Equal(uint16(1), m.Channel)
+	m = b.Read()
+	as.Equal("default", m.Name)
+	as.Equal(uint16(0), m.Channel)
+}
+
+func TestChannelFlowControl(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	slow, fast := a.Channel(1), a.Channel(2)
+	for i := 0; i < channelWindow; i++ {
+		as.Nil(slow.Write(&Message{Name: "slow" + strconv.Itoa(i)}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	as.Equal(context.DeadlineExceeded, slow.WriteContext(ctx, &Message{Name: "blocked"}))
+	cancel()
+
+	//other channels are not blocked by unread one
+	as.Nil(fast.Write(&Message{Name: "fast"}))
+	as.Equal("fast", b.Channel(2).Read().Name)
+	a.ToSendQ <- &Message{Name: "default"}
+	as.Equal("default", b.Read().Name)
+
+	done := make(chan error, 1)
+	go func() { done <- slow.Write(&Message{Name: "last"}) }()
+	for i := 0; i < channelWindow; i++ {
+		as.Equal("slow"+strconv.Itoa(i), b.Channel(1).Read().Name)
+	}
+	as.Nil(<-done)
+	as.Equal("last", b.Channel(1).Read().Name)
+}
+
+func TestChannelSyncClient(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	go func() {
+		ch := b.Channel(3)
+		for m := ch.Read(); m != nil; m = ch.Read() {
+			ch.Write(&Message{ID: m.ID, Name: m.Name, Payload: []byte("pong")})
+		}
+	}()
+
+	cl := a.Channel(3).SyncClient(time.Second)
+	as.True(cl == a.Channel(3).SyncClient(time.Second))
+	for i := 0; i < 2*channelWindow; i++ {
+		m, err := cl.WriteAndReadResponce(&Message{Name: "ping"})
+		as.Nil(err)
+		as.Equal([]byte("pong"), m.Payload)
+	}
+
+	a.Shutdown()
+	_, err := cl.WriteAndReadResponce(&Message{Name: "closed"})
+	as.Equal(ErrConnectionClosed, err)
+	as.Nil(b.Channel(3).Read())
+}
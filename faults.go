@@ -0,0 +1,124 @@
+package fdstream
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//ErrInjectedClose is returned by FaultyConn after injected abrupt close
+var ErrInjectedClose = errors.New("Injected connection close")
+
+//Faults describe failures injected by FaultyConn, rates are probabilities of failure per operation in range [0, 1]
+type Faults struct {
+	//Delay is a maximal random delay before operation, it is used with DelayRate
+	Delay     time.Duration
+	DelayRate float64
+	//ShortRate is a rate of reads and writes which handle only part of buffer, short write return io.ErrShortWrite
+	ShortRate float64
+	//CorruptRate is a rate of writes with one random byte changed
+	CorruptRate float64
+	//TruncateRate is a rate of writes which send only part of data and close connection
+	TruncateRate float64
+	//CloseRate is a rate of operations which close connection instead of read or write
+	CloseRate float64
+	//Seed of random generator, same seed give same sequence of faults for same operations
+	Seed int64
+}
+
+//FaultyConn wrap connection and inject failures into its reads and writes, it is used for chaos testing
+type FaultyConn struct {
+	conn   io.ReadWriteCloser
+	faults Faults
+
+	l      sync.Mutex
+	rnd    *rand.Rand
+	closed bool
+}
+
+//NewFaultyConn wrap connection with fault injection
+func NewFaultyConn(conn io.ReadWriteCloser, faults Faults) *FaultyConn {
+	return &FaultyConn{
+		conn:   conn,
+		faults: faults,
+		rnd:    rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+//hit decide that fault with rate happens and return random number for it
+func (f *FaultyConn) hit(rate float64, n int) (bool, int) {
+	if rate <= 0 {
+		return false, 0
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	if f.rnd.Float64() >= rate {
+		return false, 0
+	}
+	if n <= 0 {
+		return true, 0
+	}
+	return true, f.rnd.Intn(n)
+}
+
+//before inject delay and abrupt close before operation
+func (f *FaultyConn) before() error {
+	if ok, d := f.hit(f.faults.DelayRate, int(f.faults.Delay)); ok {
+		time.Sleep(time.Duration(d))
+	}
+	if ok, _ := f.hit(f.faults.CloseRate, 0); ok {
+		f.Close()
+	}
+	f.l.Lock()
+	closed := f.closed
+	f.l.Unlock()
+	if closed {
+		return ErrInjectedClose
+	}
+	return nil
+}
+
+func (f *FaultyConn) Read(b []byte) (int, error) {
+	if err := f.before(); err != nil {
+		return 0, err
+	}
+	if ok, n := f.hit(f.faults.ShortRate, len(b)); ok && n > 0 {
+		b = b[:n]
+	}
+	return f.conn.Read(b)
+}
+
+func (f *FaultyConn) Write(b []byte) (int, error) {
+	if err := f.before(); err != nil {
+		return 0, err
+	}
+	if ok, i := f.hit(f.faults.CorruptRate, len(b)); ok && len(b) > 0 {
+		corrupted := make([]byte, len(b))
+		copy(corrupted, b)
+		corrupted[i] ^= 0xff
+		b = corrupted
+	}
+	if ok, n := f.hit(f.faults.TruncateRate, len(b)); ok {
+		f.conn.Write(b[:n])
+		f.Close()
+		return n, ErrInjectedClose
+	}
+	if ok, n := f.hit(f.faults.ShortRate, len(b)); ok {
+		written, err := f.conn.Write(b[:n])
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return written, err
+	}
+	return f.conn.Write(b)
+}
+
+//Close close wrapped connection
+func (f *FaultyConn) Close() error {
+	f.l.Lock()
+	f.closed = true
+	f.l.Unlock()
+	return f.conn.Close()
+}
@@ -0,0 +1,153 @@
+package fdstream
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//checkGoroutines fail test when goroutines started after baseline are still running
+func checkGoroutines(t *testing.T, baseline int) {
+	ok := waitFor(func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+	if !ok {
+		buf := make([]byte, 1<<20)
+		t.Errorf("goroutines leaked: %d > %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+//callOrHang run call and fail test if it does not return in time
+func callOrHang(t *testing.T, c *SyncClient, m *Message) (*Message, error) {
+	type result struct {
+		m   *Message
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m, err := c.Call(ctx, m)
+		done <- result{m, err}
+	}()
+	select {
+	case r := <-done:
+		return r.m, r.err
+	case <-time.After(3 * time.Second):
+		t.Fatal("call hang")
+		return nil, nil
+	}
+}
+
+func knownCallError(err error) bool {
+	if _, ok := err.(*ResponseError); ok {
+		return true
+	}
+	return err == ErrTimeout || err == ErrConnectionClosed || err == context.DeadlineExceeded
+}
+
+func TestFaultsSyncClient(t *testing.T) {
+	scenarios := map[string]Faults{
+		"delay":    {Delay: 20 * time.Millisecond, DelayRate: 0.5},
+		"short":    {ShortRate: 0.3},
+		"corrupt":  {CorruptRate: 0.1},
+		"truncate": {TruncateRate: 0.05},
+		"close":    {CloseRate: 0.02},
+		"all": {Delay: 5 * time.Millisecond, DelayRate: 0.2, ShortRate: 0.1, CorruptRate: 0.05,
+			TruncateRate: 0.02, CloseRate: 0.01},
+	}
+	for name, faults := range scenarios {
+		for seed := int64(1); seed <= 3; seed++ {
+			faults.Seed = seed
+			t.Run(name+"-"+strconv.FormatInt(seed, 10), func(t *testing.T) {
+				runFaultsSyncClient(t, faults)
+			})
+		}
+	}
+}
+
+func runFaultsSyncClient(t *testing.T, faults Faults) {
+	as := assert.New(t)
+	baseline := runtime.NumGoroutine()
+
+	client, server := net.Pipe()
+	peer, err := NewAsyncClient(server, server)
+	as.Nil(err)
+	go echoPeer(peer)
+	conn := NewFaultyConn(client, faults)
+	c, err := NewSyncClient(conn, conn, 200*time.Millisecond)
+	as.Nil(err)
+
+	for i := 0; i < 30; i++ {
+		payload := bytes.Repeat([]byte{byte(i)}, 10+i*50)
+		m, err := callOrHang(t, c, &Message{Name: "call" + strconv.Itoa(i), Payload: payload})
+		if err != nil {
+			if !knownCallError(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+			continue
+		}
+		if faults.CorruptRate == 0 {
+			as.Equal(payload, m.Payload)
+		}
+	}
+	select {
+	case <-c.Done():
+		as.NotNil(c.Err())
+		as.NotEqual(ErrConnectionClosed, c.Err()) //reason of failure is kept
+	default:
+		as.Nil(c.Err())
+	}
+
+	c.Shutdown()
+	peer.Shutdown()
+	checkGoroutines(t, baseline)
+}
+
+func TestFaultsAsyncClient(t *testing.T) {
+	as := assert.New(t)
+	baseline := runtime.NumGoroutine()
+
+	left, right := newPipeConn(newPipeConfig(nil))
+	conn := NewFaultyConn(left, Faults{ShortRate: 0.2, TruncateRate: 0.01, Seed: 7})
+	a, err := NewAsyncClient(conn, conn, WithFragmentSize(512))
+	as.Nil(err)
+	b, err := NewAsyncClient(right, right)
+	as.Nil(err)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			select {
+			case a.ToSendQ <- &Message{Name: "m", Payload: make([]byte, i*20)}:
+			case <-a.Done():
+				return
+			}
+		}
+		a.Shutdown()
+	}()
+	received := 0
+	for {
+		select {
+		case m := <-b.ToReadQ:
+			as.Equal(make([]byte, received*20), m.Payload)
+			received++
+			continue
+		case <-b.Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("reader hang")
+		}
+		break
+	}
+	as.NotNil(b.Err())
+	as.True(received <= 100)
+
+	a.Shutdown()
+	b.Shutdown()
+	checkGoroutines(t, baseline)
+}
@@ -0,0 +1,65 @@
+package fdstream
+
+import "errors"
+
+//maxFiles is a limit of files attached to single message, it is a limit of SCM_RIGHTS too
+const maxFiles = 253
+
+var (
+	//ErrFilesNotSupported mean message with files is written or read by client without WithFiles option
+	ErrFilesNotSupported = errors.New("Files are not supported by connection")
+	//ErrTooManyFiles mean message has more files than could be passed at once
+	ErrTooManyFiles = errors.New("Too many files in message")
+	//ErrMissingFiles mean message was received without files it declare
+	ErrMissingFiles = errors.New("Files of message are missing")
+)
+
+//WithFiles enable passing Message.Files over *net.UnixConn connection, both streams of client should be the connection
+func WithFiles() Option {
+	return func(cfg *config) {
+		cfg.files = true
+	}
+}
+
+//writeMessage write message to output, message with files is written with ancillary data
+// legacy peer get plain frame
+func (c *AsyncClient) writeMessage(m *Message) (err error) {
+	if c.legacyPeer() {
+		if m, err = legacyFrame(m); err != nil {
+			return err
+		}
+	}
+	if len(m.Files) > 0 && c.files == nil {
+		err = ErrFilesNotSupported
+	} else if len(m.Files) > 0 && !c.allows(FeatureFiles) {
+		err = ErrNotNegotiated
+	} else {
+		err = c.writeFrame(m)
+	}
+	if err == nil {
+		c.sent(m)
+	}
+	return err
+}
+
+//writeFrame write marshaled message, with files connection every frame is written by it so frames do not interleave
+func (c *AsyncClient) writeFrame(m *Message) error {
+	if c.files != nil {
+		return c.files.writeMessage(m)
+	}
+	_, err := m.WriteTo(c.OutputStream)
+	return err
+}
+
+//receiveFiles fill files of message received by reader
+func (c *AsyncClient) receiveFiles(m *Message) error {
+	if c.files == nil {
+		return ErrFilesNotSupported
+	}
+	files, ok := c.files.take(len(m.Files))
+	if !ok {
+		return ErrMissingFiles
+	}
+	copy(m.Files, files)
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !unix
+
+package fdstream
+
+import (
+	"io"
+	"os"
+)
+
+//fileConn is not supported without unix sockets
+type fileConn struct{}
+
+func newFileConn(out io.Writer, in io.Reader) (*fileConn, error) {
+	return nil, ErrFilesNotSupported
+}
+
+func (f *fileConn) Read(p []byte) (int, error) {
+	return 0, ErrFilesNotSupported
+}
+
+func (f *fileConn) take(n int) ([]*os.File, bool) {
+	return nil, false
+}
+
+func (f *fileConn) writeMessage(m *Message) error {
+	return ErrFilesNotSupported
+}
+
+func (f *fileConn) close() {}
@@ -0,0 +1,117 @@
+//go:build unix
+
+package fdstream
+
+import (
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+//fileConn read and write unix socket with files as SCM_RIGHTS ancillary data
+type fileConn struct {
+	conn *net.UnixConn
+	oob  []byte
+	wl   sync.Mutex //frame with files could be written by few calls, so every frame is written under lock
+
+	l     sync.Mutex
+	files []*os.File //received files which are not taken by messages yet
+}
+
+func newFileConn(out io.Writer, in io.Reader) (*fileConn, error) {
+	conn, ok := in.(*net.UnixConn)
+	if !ok || out != io.Writer(conn) {
+		return nil, ErrFilesNotSupported
+	}
+	return &fileConn{conn: conn, oob: make([]byte, syscall.CmsgSpace(maxFiles*4))}, nil
+}
+
+//Read implements io.Reader and collect received files
+func (f *fileConn) Read(p []byte) (int, error) {
+	n, oobn, _, _, err := f.conn.ReadMsgUnix(p, f.oob)
+	if oobn > 0 {
+		f.receive(f.oob[:oobn])
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (f *fileConn) receive(oob []byte) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			f.files = append(f.files, os.NewFile(uintptr(fd), "fdstream"))
+		}
+	}
+}
+
+//take return n first received files
+func (f *fileConn) take(n int) ([]*os.File, bool) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if len(f.files) < n {
+		return nil, false
+	}
+	files := f.files[:n:n]
+	f.files = f.files[n:]
+	return files, true
+}
+
+//writeMessage write message with its files attached to first byte
+func (f *fileConn) writeMessage(m *Message) error {
+	fds := make([]int, len(m.Files))
+	for i, file := range m.Files {
+		rc, err := file.SyscallConn() //unlike Fd it does not switch file to blocking mode
+		if err != nil {
+			return err
+		}
+		rc.Control(func(fd uintptr) {
+			fds[i] = int(fd)
+		})
+	}
+	buf := getBuf()
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+	if err := m.marshalTo(buf); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	f.wl.Lock()
+	defer f.wl.Unlock()
+	if len(fds) == 0 {
+		_, err := f.conn.Write(b)
+		return err
+	}
+	n, _, err := f.conn.WriteMsgUnix(b, syscall.UnixRights(fds...), nil)
+	runtime.KeepAlive(m.Files) //descriptors should not be closed by finalizer until they are sent
+	if err == nil && n < len(b) {
+		_, err = f.conn.Write(b[n:])
+	}
+	return err
+}
+
+//close release received files which were not taken
+func (f *fileConn) close() {
+	f.l.Lock()
+	for _, file := range f.files {
+		file.Close()
+	}
+	f.files = nil
+	f.l.Unlock()
+}
@@ -0,0 +1,127 @@
+//go:build unix
+
+package fdstream
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	conn := func(fd int) *net.UnixConn {
+		f := os.NewFile(uintptr(fd), "pair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		assert.Nil(t, err)
+		return c.(*net.UnixConn)
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+func TestPassFiles(t *testing.T) {
+	as := assert.New(t)
+	left, right := unixPair(t)
+	a, err := NewAsyncClient(left, left, WithFiles(), WithFragmentSize(1024))
+	as.Nil(err)
+	defer a.Shutdown()
+	b, err := NewAsyncClient(right, right, WithFiles())
+	as.Nil(err)
+	defer b.Shutdown()
+
+	r, w, err := os.Pipe()
+	as.Nil(err)
+	defer r.Close()
+	defer w.Close()
+
+	a.ToSendQ <- &Message{Name: "plain"}
+	a.ToSendQ <- &Message{Name: "log", Payload: make([]byte, 5000), Files: []*os.File{w, w}}
+	as.Equal("plain", (<-b.ToReadQ).Name)
+	m := <-b.ToReadQ
+	as.Equal("log", m.Name)
+	as.Len(m.Payload, 5000)
+	as.Len(m.Files, 2)
+
+	for _, f := range m.Files {
+		_, err = f.Write([]byte("hi "))
+		as.Nil(err)
+		f.Close()
+	}
+	w.Close()
+	got, err := ioutil.ReadAll(r)
+	as.Nil(err)
+	as.Equal("hi hi ", string(got))
+}
+
+func TestPassFilesConcurrent(t *testing.T) {
+	as := assert.New(t)
+	left, right := unixPair(t)
+	a, err := NewAsyncClient(left, left, WithFiles())
+	as.Nil(err)
+	defer a.Shutdown()
+	b, err := NewAsyncClient(right, right, WithFiles())
+	as.Nil(err)
+	defer b.Shutdown()
+	r, w, err := os.Pipe()
+	as.Nil(err)
+	defer r.Close()
+	defer w.Close()
+
+	//frames with files and plain frames written directly by few goroutines do not interleave
+	const writers, count = 4, 50
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				m := &Message{Name: strconv.Itoa(i), Payload: make([]byte, 60000)}
+				if j%2 == 0 {
+					m.Files = []*os.File{w}
+				}
+				a.Write(m)
+			}
+		}(i)
+	}
+	for i := 0; i < writers*count; i++ {
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond) //let socket buffer fill so frames are written partially
+		}
+		m := <-b.ToReadQ
+		as.Len(m.Payload, 60000)
+		for _, f := range m.Files {
+			f.Close()
+		}
+	}
+	wg.Wait()
+	as.True(b.IsAlive())
+}
+
+func TestFilesNotSupported(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	_, err := NewAsyncClient(left, left, WithFiles())
+	as.Equal(ErrFilesNotSupported, err)
+
+	a, err := NewAsyncClient(left, left)
+	as.Nil(err)
+	defer right.Close()
+	defer a.Shutdown()
+	as.Equal(ErrFilesNotSupported, a.Send(&Message{Name: "file", Files: []*os.File{os.Stdout}}))
+	a.ToSendQ <- &Message{Name: "file", Files: []*os.File{os.Stdout}} //dropped by writer
+	a.ToSendQ <- &Message{Name: "next"}
+	m, err := readMessage(right, make([]byte, messageHeaderSize))
+	as.Nil(err)
+	as.Equal("next", m.Name)
+	as.True(a.IsAlive())
+	as.Nil(a.Err())
+}
@@ -0,0 +1,95 @@
+package fdstream
+
+//flowControl keep credits of default channel when WithFlowControl is used
+type flowControl struct {
+	messages *credit //send credits granted by peer
+	bytes    *credit //nil if bytes are not limited
+	inbox    chan *Message
+
+	//read side counters are used only by pump worker
+	msgWindow, byteWindow     int64
+	consumedMsgs, consumedLen int64
+}
+
+func newFlowControl(messages, bytes int) *flowControl {
+	f := &flowControl{
+		messages:   newCredit(int64(messages)),
+		inbox:      make(chan *Message, messages),
+		msgWindow:  int64(messages),
+		byteWindow: int64(bytes),
+	}
+	if bytes > 0 {
+		f.bytes = newCredit(int64(bytes))
+	}
+	return f
+}
+
+//limits check that message is limited by window of default channel,
+// channels and streams have own window and control messages are never blocked
+func (f *flowControl) limits(m *Message) bool {
+	return m.Channel == 0 && m.Code != ctrlCreditCode && m.fragment&flagStream == 0
+}
+
+//limited check that message wait credits before it is written, peer without negotiated flow control does not grant credits
+func (c *AsyncClient) limited(m *Message) bool {
+	return c.flow != nil && c.flow.limits(m) && c.allows(FeatureFlowControl)
+}
+
+//tryAcquire take credits for limited message without waiting,
+// on failure it return chan which is closed when peer grant missing credits
+func (f *flowControl) tryAcquire(m *Message) (bool, <-chan struct{}) {
+	ok, wait := f.messages.tryAcquire(1)
+	if !ok || f.bytes == nil {
+		return ok, wait
+	}
+	if ok, wait = f.bytes.tryAcquire(int64(m.Len())); !ok {
+		f.messages.add(1)
+	}
+	return ok, wait
+}
+
+//deliver put income message to inbox, peer which ignore window break connection,
+// peer without negotiated flow control does not know window so reader wait for space in inbox
+func (f *flowControl) deliver(c *AsyncClient, m *Message) bool {
+	select {
+	case f.inbox <- m:
+		return true
+	default:
+	}
+	if c.allows(FeatureFlowControl) {
+		c.fail(ErrWindowExceeded)
+		return true
+	}
+	select {
+	case f.inbox <- m:
+	case <-c.kill:
+	}
+	return true
+}
+
+//workerPump move messages from inbox to unbuffered ToReadQ and grant credits for every read one
+func (c *AsyncClient) workerPump(f *flowControl) {
+	for {
+		var m *Message
+		select {
+		case m = <-f.inbox:
+		case <-c.kill:
+			return
+		}
+		select {
+		case c.ToReadQ <- m:
+		case <-c.kill:
+			return
+		}
+
+		f.consumedMsgs++
+		f.consumedLen += int64(m.Len())
+		if !c.allows(FeatureFlowControl) {
+			continue //peer does not wait for credits
+		}
+		if len(f.inbox) == 0 || f.consumedMsgs >= f.msgWindow/4 || (f.byteWindow > 0 && f.consumedLen >= f.byteWindow/4) {
+			c.writeControl(newCreditMessage(0, uint32(f.consumedMsgs), uint32(f.consumedLen)))
+			f.consumedMsgs, f.consumedLen = 0, 0
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package fdstream
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitFor(f func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestFlowControlMessages(t *testing.T) {
+	as := assert.New(t)
+	opts := []Option{WithFlowControl(4, 0)}
+	a, b := newPeers(t, opts, opts)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	for i := 0; i < 10; i++ {
+		a.ToSendQ <- &Message{Name: "m" + strconv.Itoa(i)}
+	}
+	//4 messages are sent and others are parked by writer until credits are granted
+	as.True(waitFor(func() bool { return a.Stats().MessagesSent == 4 && len(a.ToSendQ) == 0 }))
+	time.Sleep(20 * time.Millisecond)
+	as.Equal(uint64(4), a.Stats().MessagesSent)
+
+	for i := 0; i < 10; i++ {
+		as.Equal("m"+strconv.Itoa(i), b.Read().Name)
+	}
+}
+
+func TestFlowControlBytes(t *testing.T) {
+	as := assert.New(t)
+	opts := []Option{WithFlowControl(100, 50)}
+	a, b := newPeers(t, opts, opts)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	payload := make([]byte, 11) //20 bytes with header
+	for i := 0; i < 6; i++ {
+		a.ToSendQ <- &Message{Payload: payload}
+	}
+	as.True(waitFor(func() bool { return a.Stats().MessagesSent == 2 }))
+
+	big := make([]byte, 100) //bigger than window is sent when all credits are free
+	a.ToSendQ <- &Message{Name: "big", Payload: big}
+	for i := 0; i < 6; i++ {
+		as.Equal(payload, b.Read().Payload)
+	}
+	as.Equal("big", b.Read().Name)
+}
+
+func TestFlowControlBothDirections(t *testing.T) {
+	opts := []Option{WithFlowControl(4, 0)}
+	a, b := newPeers(t, opts, opts)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	for i := 0; i < 10; i++ {
+		a.ToSendQ <- &Message{Name: "a"}
+		b.ToSendQ <- &Message{Name: "b"}
+	}
+	//credit messages are not blocked by data waiting in writers
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, pair := range [][2]*AsyncClient{{a, b}, {b, a}} {
+		go func(reader *AsyncClient) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				reader.Read()
+			}
+		}(pair[0])
+	}
+	wg.Wait()
+}
+
+func TestFlowControlNotBlockWriter(t *testing.T) {
+	as := assert.New(t)
+	opts := []Option{WithFlowControl(2, 0)}
+	a, b := newPeers(t, opts, opts)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	for i := 0; i < 5; i++ {
+		a.ToSendQ <- &Message{Name: "starved"}
+	}
+	as.True(waitFor(func() bool { return a.Stats().MessagesSent == 2 }))
+	//credits of default channel are exhausted but channel and control messages are still written
+	as.Nil(a.Channel(1).Write(&Message{Name: "channel"}))
+	as.Equal("channel", b.Channel(1).Read().Name)
+	a.Write(&Message{Name: "last"}) //limited message is queued and caller is not blocked
+
+	for i := 0; i < 5; i++ {
+		as.Equal("starved", b.Read().Name)
+	}
+	as.Equal("last", b.Read().Name)
+}
+
+func TestFlowControlWindowExceeded(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, []Option{WithFlowControl(2, 0)})
+	defer a.Shutdown()
+
+	for i := 0; i < 5; i++ {
+		a.ToSendQ <- &Message{Name: "flood"}
+	}
+	as.True(waitFor(func() bool { return !b.IsAlive() }))
+	as.Equal(ErrWindowExceeded, b.Err())
+}
+
+func TestFlowControlCodeWithoutFlow(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	a.ToSendQ <- &Message{ID: 1, Code: ctrlCreditCode, Payload: []byte("error text")}
+	m := b.Read()
+	as.Equal(ctrlCreditCode, m.Code)
+	as.Equal([]byte("error text"), m.Payload)
+}
@@ -0,0 +1,186 @@
+package fdstream
+
+import "errors"
+
+const (
+	//maxPayloadLen is a biggest payload which fit to single frame
+	maxPayloadLen = 1<<16 - 1
+	//defaultFragmentSize is a payload size of fragment, bigger payloads are split by writer
+	defaultFragmentSize = maxPayloadLen
+	//defaultReassemblyLimit is a memory limit for incomplete messages of connection
+	defaultReassemblyLimit = 64 << 20
+)
+
+var (
+	//ErrPayloadTooLong mean payload could not be encoded in single frame
+	ErrPayloadTooLong = errors.New("Too long payload of message")
+	//ErrReassemblyLimit mean peer send more incomplete fragmented messages than reader can hold
+	ErrReassemblyLimit = errors.New("Reassembly memory limit exceeded")
+	//ErrFragmentSequence mean fragment came without start or message started twice
+	ErrFragmentSequence = errors.New("Incorrect sequence of fragments")
+)
+
+//transfer is a fragmented message in progress of writing
+type transfer struct {
+	m      *Message
+	offset int
+	queued []*Message //messages with same channel and ID which wait end of transfer
+}
+
+//fragmentKey identify fragmented message of connection
+func fragmentKey(m *Message) uint64 {
+	return uint64(m.Channel)<<32 | uint64(m.ID)
+}
+
+//nextFragment return next fragment of message, first one carry name and other fields,
+// every fragment except last one has flagMore
+func (t *transfer) nextFragment(size int) *Message {
+	end := t.offset + size
+	if end > len(t.m.Payload) {
+		end = len(t.m.Payload)
+	}
+	f := &Message{
+		Code:    t.m.Code,
+		ID:      t.m.ID,
+		Channel: t.m.Channel,
+		Payload: t.m.Payload[t.offset:end],
+	}
+	if t.offset == 0 {
+		f.Name, f.Idempotent, f.Files = t.m.Name, t.m.Idempotent, t.m.Files
+		f.KeyID, f.Signature = t.m.KeyID, t.m.Signature
+		f.Metadata = t.m.Metadata
+	} else {
+		f.fragment = flagContinuation
+	}
+	if end < len(t.m.Payload) {
+		f.fragment |= flagMore
+	}
+	t.offset = end
+	return f
+}
+
+func (t *transfer) done() bool {
+	return t.offset >= len(t.m.Payload)
+}
+
+//fragmenter interleave fragments of big messages, one fragment of every transfer in turn
+type fragmenter struct {
+	size      int
+	transfers []*transfer
+	keys      map[uint64]*transfer
+	next      int
+}
+
+func newFragmenter(size int) *fragmenter {
+	return &fragmenter{size: size, keys: make(map[uint64]*transfer)}
+}
+
+//add start transfer of message, message with same key as transfer in progress wait for it
+func (f *fragmenter) add(m *Message) {
+	key := fragmentKey(m)
+	if t, ok := f.keys[key]; ok {
+		t.queued = append(t.queued, m)
+		return
+	}
+	t := &transfer{m: m}
+	f.keys[key] = t
+	f.transfers = append(f.transfers, t)
+}
+
+//takes check that message should be written by fragmenter, it is big or transfer with same key is in progress
+func (f *fragmenter) takes(m *Message) bool {
+	if len(m.Payload) > f.size {
+		return true
+	}
+	_, ok := f.keys[fragmentKey(m)]
+	return ok
+}
+
+func (f *fragmenter) active() bool {
+	return len(f.transfers) > 0
+}
+
+//fragment return next fragment in round robin order, queued message with same key
+// replace finished transfer and is fragmented only if it is big
+func (f *fragmenter) fragment() *Message {
+	if f.next >= len(f.transfers) {
+		f.next = 0
+	}
+	t := f.transfers[f.next]
+	var m *Message
+	if t.offset == 0 && len(t.m.Payload) <= f.size {
+		m, t.offset = t.m, len(t.m.Payload)
+	} else {
+		m = t.nextFragment(f.size)
+	}
+	if !t.done() {
+		f.next++
+		return m
+	}
+	if len(t.queued) > 0 {
+		t.m, t.offset, t.queued = t.queued[0], 0, t.queued[1:]
+		f.next++
+		return m
+	}
+	delete(f.keys, fragmentKey(t.m))
+	f.transfers = append(f.transfers[:f.next], f.transfers[f.next+1:]...)
+	return m
+}
+
+//reassembler collect fragments of messages until last one come, it is used by single reader
+type reassembler struct {
+	limit   int
+	used    int
+	pending map[uint64]*Message
+}
+
+func newReassembler(limit int) *reassembler {
+	return &reassembler{limit: limit, pending: make(map[uint64]*Message)}
+}
+
+//add take fragment and return whole message when it is complete, nil mean more fragments are expected
+func (r *reassembler) add(m *Message) (*Message, error) {
+	key := fragmentKey(m)
+	p, ok := r.pending[key]
+	if ok == (m.fragment&flagContinuation == 0) {
+		return nil, ErrFragmentSequence
+	}
+	var buf []byte
+	if ok {
+		buf = p.Payload
+	}
+	buf, err := r.grow(buf, len(m.Payload))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		p = m
+		r.pending[key] = p
+	}
+	p.Payload = append(buf, m.Payload...)
+	if m.fragment&flagMore != 0 {
+		return nil, nil
+	}
+	delete(r.pending, key)
+	r.used -= cap(p.Payload)
+	p.fragment = 0
+	return p, nil
+}
+
+//grow make room for n more bytes in buffer of pending message, memory is accounted by capacity of buffers
+// buffer is doubled while it fit to limit, otherwise it get exact size
+func (r *reassembler) grow(buf []byte, n int) ([]byte, error) {
+	need := len(buf) + n
+	if need <= cap(buf) {
+		return buf, nil
+	}
+	size := 2 * cap(buf)
+	if size < need || r.used+size-cap(buf) > r.limit {
+		size = need
+	}
+	if r.used+size-cap(buf) > r.limit {
+		return nil, ErrReassemblyLimit
+	}
+	r.used += size - cap(buf)
+	return append(make([]byte, 0, size), buf...), nil
+}
@@ -0,0 +1,112 @@
+package fdstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFragmentedRoundTrip(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	payload := bytes.Repeat([]byte("0123456789"), 1e5)
+	a.ToSendQ <- &Message{Name: "big", ID: 7, Code: 3, Payload: payload, Idempotent: true}
+	m := <-b.ToReadQ
+	as.Equal(&Message{Name: "big", ID: 7, Code: 3, Payload: payload, Idempotent: true}, m)
+}
+
+func TestFragmentsInterleaved(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithFragmentSize(1024)}, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	a.ToSendQ <- &Message{Name: "big", Payload: make([]byte, 1<<20)}
+	a.ToSendQ <- &Message{Name: "small", ID: 1, Payload: []byte("x")}
+	first := <-b.ToReadQ
+	second := <-b.ToReadQ
+	as.Equal("small", first.Name)
+	as.Equal("big", second.Name)
+	as.Len(second.Payload, 1<<20)
+}
+
+func TestFragmentsSameKeyInOrder(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithFragmentSize(100)}, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	ch := a.Channel(2)
+	as.Nil(ch.Write(&Message{Name: "one", Payload: bytes.Repeat([]byte("1"), 1000)}))
+	as.Nil(ch.Write(&Message{Name: "two", Payload: []byte("2")}))
+	as.Nil(ch.Write(&Message{Name: "three", Payload: bytes.Repeat([]byte("3"), 250)}))
+
+	in := b.Channel(2)
+	for i, expected := range []string{"one", "two", "three"} {
+		m := in.Read()
+		as.Equal(expected, m.Name)
+		as.Equal(bytes.Repeat([]byte{'1' + byte(i)}, []int{1000, 1, 250}[i]), m.Payload)
+	}
+}
+
+func TestReassemblyLimit(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithFragmentSize(512)}, []Option{WithReassemblyLimit(1000)})
+	defer a.Shutdown()
+
+	a.ToSendQ <- &Message{Name: "too big", Payload: make([]byte, 4000)}
+	<-b.Done()
+	as.Equal(ErrReassemblyLimit, b.Err())
+}
+
+func TestReassemblerSequence(t *testing.T) {
+	as := assert.New(t)
+	r := newReassembler(1 << 10)
+
+	_, err := r.add(&Message{ID: 1, fragment: flagContinuation})
+	as.Equal(ErrFragmentSequence, err)
+
+	m, err := r.add(&Message{ID: 1, Name: "a", Payload: []byte("ab"), fragment: flagMore})
+	as.Nil(m)
+	as.Nil(err)
+	_, err = r.add(&Message{ID: 1, Payload: []byte("cd"), fragment: flagMore})
+	as.Equal(ErrFragmentSequence, err)
+	m, err = r.add(&Message{ID: 1, Payload: []byte("cd"), fragment: flagContinuation})
+	as.Nil(err)
+	as.Equal(&Message{ID: 1, Name: "a", Payload: []byte("abcd")}, m)
+	as.Equal(0, r.used)
+}
+
+func TestReassemblerAccounting(t *testing.T) {
+	as := assert.New(t)
+	r := newReassembler(1000)
+
+	//allocated buffers are accounted, so they never grow over limit
+	_, err := r.add(&Message{ID: 1, Payload: make([]byte, 400), fragment: flagMore})
+	as.Nil(err)
+	as.Equal(400, r.used)
+	_, err = r.add(&Message{ID: 1, Payload: make([]byte, 400), fragment: flagMore | flagContinuation})
+	as.Nil(err)
+	as.Equal(800, r.used)
+	as.Equal(800, cap(r.pending[1].Payload))
+	m, err := r.add(&Message{ID: 1, Payload: make([]byte, 200), fragment: flagContinuation})
+	as.Nil(err)
+	as.Len(m.Payload, 1000)
+	as.Equal(1000, cap(m.Payload))
+	as.Equal(0, r.used)
+
+	_, err = r.add(&Message{ID: 2, Payload: make([]byte, 600), fragment: flagMore})
+	as.Nil(err)
+	_, err = r.add(&Message{ID: 3, Payload: make([]byte, 500), fragment: flagMore})
+	as.Equal(ErrReassemblyLimit, err)
+}
+
+func TestMarshalPayloadTooLong(t *testing.T) {
+	as := assert.New(t)
+	_, err := (&Message{Payload: make([]byte, maxPayloadLen+1)}).Marshal()
+	as.Equal(ErrPayloadTooLong, err)
+}
@@ -0,0 +1,237 @@
+package fdstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//ProtocolVersion is a version of protocol sent in hello
+	ProtocolVersion byte = 1
+	//ctrlHelloCode is a code of control message with hello of peer,
+	// name of message is a peer name, payload is version, uint32 features and uint32 max message size
+	ctrlHelloCode byte = 248
+)
+
+var (
+	//ErrNoHandshake mean peer did not answer hello in time, it is a legacy peer
+	ErrNoHandshake = errors.New("Peer did not answer handshake")
+	//ErrNotNegotiated mean message need feature which was not negotiated with peer
+	ErrNotNegotiated = errors.New("Feature is not supported by peer")
+)
+
+//Features is a set of protocol extensions supported by side of connection
+type Features uint32
+
+//Protocol extensions which could be negotiated
+const (
+	FeatureChannels Features = 1 << iota
+	FeatureFragments
+	FeatureStreams
+	FeatureFlowControl
+	FeatureFiles
+)
+
+//Has check that all features of f are in set
+func (s Features) Has(f Features) bool {
+	return s&f == f
+}
+
+//Hello describe side of connection
+type Hello struct {
+	Version        byte
+	Features       Features
+	MaxMessageSize uint32
+	Name           string
+}
+
+//handshake keep hello exchange of client
+type handshake struct {
+	local   *Hello
+	timeout time.Duration //zero mean hello is not used and peer hello is a usual message
+
+	l      sync.Mutex
+	sent   bool
+	peer   *Hello
+	done   chan struct{}
+	once   sync.Once
+	legacy int32 //peer did not answer hello, only plain frames are written
+	//allowed are features which could be used with peer, all of them without handshake
+	// and negotiated ones when handshake is done
+	allowed uint32
+}
+
+//WithHandshake send hello with name and supported features when connection start,
+// peer which does not answer in timeout is treated as legacy one without extensions
+func WithHandshake(name string, timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.helloName, cfg.helloTimeout = name, timeout
+		if cfg.helloTimeout <= 0 {
+			cfg.helloTimeout = time.Second
+		}
+	}
+}
+
+func newHandshake(cfg config) *handshake {
+	features := FeatureChannels | FeatureFragments | FeatureStreams
+	if cfg.flowMessages > 0 {
+		features |= FeatureFlowControl
+	}
+	if cfg.files {
+		features |= FeatureFiles
+	}
+	h := &handshake{
+		local: &Hello{
+			Version:        ProtocolVersion,
+			Features:       features,
+			MaxMessageSize: uint32(cfg.reassemblyLimit),
+			Name:           cfg.helloName,
+		},
+		timeout: cfg.helloTimeout,
+		done:    make(chan struct{}),
+	}
+	if h.timeout <= 0 {
+		h.allowed = ^uint32(0)
+		h.finish(nil)
+	}
+	return h
+}
+
+//start send hello and wait answer in background
+func (h *handshake) start(c *AsyncClient) {
+	h.send(c)
+	go func() {
+		timer := time.NewTimer(h.timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			h.finish(nil)
+		case <-h.done:
+		case <-c.kill:
+			h.finish(nil)
+		}
+	}()
+}
+
+//send write hello once
+func (h *handshake) send(c *AsyncClient) {
+	h.l.Lock()
+	sent := h.sent
+	h.sent = true
+	h.l.Unlock()
+	if sent {
+		return
+	}
+	payload := make([]byte, 9)
+	payload[0] = h.local.Version
+	binary.BigEndian.PutUint32(payload[1:5], uint32(h.local.Features))
+	binary.BigEndian.PutUint32(payload[5:9], h.local.MaxMessageSize)
+	c.writeControl(&Message{Code: ctrlHelloCode, Name: h.local.Name, Payload: payload})
+}
+
+func (h *handshake) finish(peer *Hello) {
+	h.once.Do(func() {
+		h.l.Lock()
+		h.peer = peer
+		h.l.Unlock()
+		if peer == nil && h.timeout > 0 {
+			atomic.StoreInt32(&h.legacy, 1)
+		}
+		if peer != nil {
+			atomic.StoreUint32(&h.allowed, uint32(h.local.Features&peer.Features))
+		}
+		close(h.done)
+	})
+}
+
+//receiveHello answer hello of peer if it was not sent yet and finish handshake
+func (c *AsyncClient) receiveHello(m *Message) {
+	if len(m.Payload) < 9 {
+		return
+	}
+	peer := &Hello{
+		Version:        m.Payload[0],
+		Features:       Features(binary.BigEndian.Uint32(m.Payload[1:5])),
+		MaxMessageSize: binary.BigEndian.Uint32(m.Payload[5:9]),
+		Name:           m.Name,
+	}
+	c.hello.send(c)
+	c.hello.finish(peer)
+}
+
+//Handshake wait end of hello exchange and return hello of peer, ErrNoHandshake is returned for legacy peer
+// and without WithHandshake option
+func (c *AsyncClient) Handshake() (*Hello, error) {
+	select {
+	case <-c.hello.done:
+	case <-c.kill:
+	}
+	c.hello.l.Lock()
+	defer c.hello.l.Unlock()
+	if c.hello.peer == nil {
+		return nil, ErrNoHandshake
+	}
+	return c.hello.peer, nil
+}
+
+//HandshakeDone return chan which is closed when hello exchange is finished or timed out
+func (c *AsyncClient) HandshakeDone() <-chan struct{} {
+	return c.hello.done
+}
+
+//Negotiated return features supported by both sides, it is empty until handshake is done and for legacy peer
+func (c *AsyncClient) Negotiated() Features {
+	c.hello.l.Lock()
+	defer c.hello.l.Unlock()
+	if c.hello.peer == nil {
+		return 0
+	}
+	return c.hello.local.Features & c.hello.peer.Features
+}
+
+//negotiating check that hello is sent and answer of peer is not received yet
+func (c *AsyncClient) negotiating() bool {
+	select {
+	case <-c.hello.done:
+		return false
+	default:
+		return true
+	}
+}
+
+//legacyPeer check that handshake failed and peer understand only plain frames
+func (c *AsyncClient) legacyPeer() bool {
+	return atomic.LoadInt32(&c.hello.legacy) != 0
+}
+
+//allows check that feature could be used with peer, it is false until handshake is done
+func (c *AsyncClient) allows(f Features) bool {
+	return Features(atomic.LoadUint32(&c.hello.allowed)).Has(f)
+}
+
+//negotiate wait end of handshake and check that feature was negotiated with peer
+func (c *AsyncClient) negotiate(f Features) error {
+	select {
+	case <-c.hello.done:
+	case <-c.kill:
+		return ErrConnectionClosed
+	}
+	if !c.allows(f) {
+		return ErrNotNegotiated
+	}
+	return nil
+}
+
+//legacyFrame return copy of message without fields of extended header, idempotent flag,
+// signature and metadata are dropped while channels, streams and files could not be sent to legacy peer
+func legacyFrame(m *Message) (*Message, error) {
+	if m.Channel != 0 || m.fragment != 0 || len(m.Files) > 0 {
+		return nil, ErrNotNegotiated
+	}
+	plain := *m
+	plain.Idempotent, plain.KeyID, plain.Signature, plain.Metadata = false, "", nil, nil
+	return &plain, nil
+}
@@ -0,0 +1,127 @@
+package fdstream
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t,
+		[]Option{WithHandshake("client", time.Second), WithFlowControl(10, 0)},
+		[]Option{WithHandshake("server", time.Second)})
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	hello, err := a.Handshake()
+	as.Nil(err)
+	as.Equal("server", hello.Name)
+	as.Equal(ProtocolVersion, hello.Version)
+	as.Equal(uint32(defaultReassemblyLimit), hello.MaxMessageSize)
+	as.True(hello.Features.Has(FeatureStreams | FeatureFragments))
+	as.False(hello.Features.Has(FeatureFlowControl))
+
+	hello, err = b.Handshake()
+	as.Nil(err)
+	as.Equal("client", hello.Name)
+	as.True(hello.Features.Has(FeatureFlowControl))
+	as.Equal(FeatureChannels|FeatureFragments|FeatureStreams, a.Negotiated())
+	as.Equal(a.Negotiated(), b.Negotiated())
+
+	a.ToSendQ <- &Message{Name: "after hello"}
+	as.Equal("after hello", (<-b.ToReadQ).Name)
+}
+
+//side without WithHandshake option read hello as usual message
+func TestHandshakeNotConfigured(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithHandshake("client", 20*time.Millisecond)}, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	_, err := b.Handshake()
+	as.Equal(ErrNoHandshake, err)
+	m := b.Read()
+	as.Equal(ctrlHelloCode, m.Code)
+	as.Equal("client", m.Name)
+	_, err = a.Handshake()
+	as.Equal(ErrNoHandshake, err)
+}
+
+//legacy peer read frames but never answer hello
+func TestHandshakeLegacyPeer(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	defer right.Close()
+	frames := make(chan *Message, 10)
+	go func() {
+		header := make([]byte, messageHeaderSize)
+		for {
+			m, err := readMessage(right, header)
+			if err != nil {
+				return
+			}
+			frames <- m
+		}
+	}()
+
+	a, err := NewAsyncClient(left, left, WithHandshake("client", 20*time.Millisecond), WithFragmentSize(16),
+		WithFlowControl(1, 0), WithSigner(Signer{KeyID: "k", Key: []byte("secret")}))
+	as.Nil(err)
+	defer a.Shutdown()
+	a.Write(&Message{Name: "early", Idempotent: true, Metadata: map[string]string{"k": "v"}})
+	as.Equal(ctrlHelloCode, (<-frames).Code)
+	_, err = a.Handshake()
+	as.Equal(ErrNoHandshake, err)
+	as.Equal(Features(0), a.Negotiated())
+	as.Nil(a.Err())
+
+	//plain frames without fragments, signature and metadata are sent after failed handshake
+	a.ToSendQ <- &Message{Name: "big", Payload: make([]byte, 100)}
+	a.ToSendQ <- &Message{Name: "over window"}
+	as.Equal(ErrNotNegotiated, a.SendStream("stream", bytes.NewReader([]byte("body"))))
+	as.Equal(ErrNotNegotiated, a.Channel(1).Write(&Message{Name: "channel"}))
+	for _, name := range []string{"early", "big", "over window"} {
+		m := <-frames
+		as.Equal(name, m.Name)
+		as.Equal(byte(0), m.flags())
+		as.Nil(m.Metadata)
+		as.Nil(m.Signature)
+	}
+}
+
+//features which are used by one side only are not negotiated
+func TestHandshakeOneSidedFeatures(t *testing.T) {
+	as := assert.New(t)
+	for _, limited := range []bool{true, false} {
+		flow := []Option{WithHandshake("flow", time.Second), WithFlowControl(2, 0)}
+		plain := []Option{WithHandshake("plain", time.Second)}
+		a, b := newPeers(t, plain, flow)
+		if limited {
+			a, b = newPeers(t, flow, plain)
+		}
+		_, err := a.Handshake()
+		as.Nil(err)
+		as.False(a.Negotiated().Has(FeatureFlowControl))
+
+		for i := 0; i < 5; i++ {
+			a.ToSendQ <- &Message{Name: "m" + strconv.Itoa(i)}
+		}
+		for i := 0; i < 5; i++ {
+			select {
+			case m := <-b.ToReadQ:
+				as.Equal("m"+strconv.Itoa(i), m.Name)
+			case <-time.After(time.Second):
+				t.Fatal("Messages should not wait for credits")
+			}
+		}
+		as.Nil(b.Err())
+		a.Shutdown()
+		b.Shutdown()
+	}
+}
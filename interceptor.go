@@ -0,0 +1,42 @@
+package fdstream
+
+import "context"
+
+//Invoker send request and wait responce, it is the rest of client interceptor chain
+type Invoker func(ctx context.Context, m *Message) (*Message, error)
+
+//ClientInterceptor wrap every call of sync client, it should call next to send message
+// and may change context, message, responce and error
+type ClientInterceptor func(ctx context.Context, m *Message, next Invoker) (*Message, error)
+
+//ServerInterceptor wrap every handler invocation of server, it should call next to run handler
+type ServerInterceptor func(m *Message, next Handler) *Message
+
+//WithInterceptors add interceptors to calls of sync client, first one is outermost
+func WithInterceptors(interceptors ...ClientInterceptor) Option {
+	return func(cfg *config) {
+		cfg.interceptors = append(cfg.interceptors, interceptors...)
+	}
+}
+
+//chainInvoker wrap invoker by interceptors, first one is outermost
+func chainInvoker(invoker Invoker, interceptors []ClientInterceptor) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, m *Message) (*Message, error) {
+			return interceptor(ctx, m, next)
+		}
+	}
+	return invoker
+}
+
+//chainHandler wrap handler by interceptors, first one is outermost
+func chainHandler(h Handler, interceptors []ServerInterceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = HandlerFunc(func(m *Message) *Message {
+			return interceptor(m, next)
+		})
+	}
+	return h
+}
@@ -0,0 +1,102 @@
+package fdstream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptors(t *testing.T) {
+	as := assert.New(t)
+	var order []string
+	record := func(name string) ServerInterceptor {
+		return func(m *Message, next Handler) *Message {
+			order = append(order, name)
+			return next.ServeMessage(m)
+		}
+	}
+	requireToken := func(m *Message, next Handler) *Message {
+		if m.Metadata["token"] != "secret" {
+			return &Message{Code: CodeUnauthorized, Name: "no token"}
+		}
+		return next.ServeMessage(m)
+	}
+	s := &Server{
+		Handler:      HandlerFunc(upperHandler),
+		Interceptors: []ServerInterceptor{record("first"), record("second"), requireToken},
+		Workers:      1,
+	}
+	defer s.Close()
+	a, b := net.Pipe()
+	go s.ServeConn(b)
+
+	var calls int
+	addToken := func(ctx context.Context, m *Message, next Invoker) (*Message, error) {
+		if m.Name != "anonymous" {
+			m.Metadata = map[string]string{"token": "secret"}
+		}
+		return next(ctx, m)
+	}
+	count := func(ctx context.Context, m *Message, next Invoker) (*Message, error) {
+		calls++
+		resp, err := next(ctx, m)
+		if err == nil {
+			resp.Name = "seen " + resp.Name
+		}
+		return resp, err
+	}
+	cl, err := NewSyncClient(a, a, time.Second, WithInterceptors(count), WithInterceptors(addToken))
+	as.Nil(err)
+	defer cl.Shutdown()
+
+	resp, err := cl.Call(context.Background(), &Message{Name: "n", Payload: []byte("abc")})
+	as.Nil(err)
+	as.Equal([]byte("ABC"), resp.Payload)
+	as.Equal("seen n", resp.Name)
+	as.Equal([]string{"first", "second"}, order)
+
+	_, err = cl.Call(context.Background(), &Message{Name: "anonymous"})
+	code, _ := ErrorCode(err)
+	as.Equal(CodeUnauthorized, code)
+	as.Equal(2, calls)
+}
+
+func TestClientInterceptorShortCircuit(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(time.Second)
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	cached := &Message{Name: "cached"}
+	a.invoke = chainInvoker(a.send, []ClientInterceptor{
+		func(ctx context.Context, m *Message, next Invoker) (*Message, error) {
+			return cached, nil
+		},
+	})
+
+	resp, err := a.Call(context.Background(), &Message{Name: "n"})
+	as.Nil(err)
+	as.Equal(cached, resp)
+	as.Equal(uint64(0), a.Stats().MessagesSent)
+}
+
+func TestInterceptorsRetry(t *testing.T) {
+	as := assert.New(t)
+	var attempts int
+	a, b, err := SyncPipe(50*time.Millisecond, WithClientOptions(WithInterceptors(
+		func(ctx context.Context, m *Message, next Invoker) (*Message, error) {
+			attempts++
+			return next(ctx, m)
+		})))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	_, err = a.CallWithRetry(context.Background(), &Message{Name: "lost", Idempotent: true},
+		RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	as.Equal(ErrTimeout, err)
+	as.Equal(3, attempts)
+}
@@ -0,0 +1,86 @@
+package fdstream
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+)
+
+//LogLevels are levels of events reported by logger of client or server
+type LogLevels struct {
+	//Connection is a level of connection open and close, default Info
+	Connection slog.Level
+	//Error is a level of decode and protocol errors, default Warn
+	Error slog.Level
+	//Drop is a level of messages dropped without delivery, default Warn
+	Drop slog.Level
+	//Timeout is a level of calls finished by timeout, default Warn
+	Timeout slog.Level
+}
+
+//DefaultLogLevels are used by WithLogger
+var DefaultLogLevels = LogLevels{
+	Connection: slog.LevelInfo,
+	Error:      slog.LevelWarn,
+	Drop:       slog.LevelWarn,
+	Timeout:    slog.LevelWarn,
+}
+
+//WithLogger report connection open and close, decode errors, dropped messages and timeouts to logger,
+// client without logger is silent
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+	}
+}
+
+//WithLogLevels change levels of events reported by WithLogger
+func WithLogLevels(levels LogLevels) Option {
+	return func(cfg *config) {
+		cfg.logLevels = levels
+	}
+}
+
+//clientLogger add peer of connection to events of client
+type clientLogger struct {
+	*slog.Logger
+	levels LogLevels
+}
+
+func newClientLogger(cfg config, income io.Reader) *clientLogger {
+	if cfg.logger == nil {
+		return nil
+	}
+	l := cfg.logger
+	if conn, ok := income.(net.Conn); ok && conn.RemoteAddr() != nil {
+		l = l.With(slog.String("peer", conn.RemoteAddr().String()))
+	}
+	return &clientLogger{Logger: l, levels: cfg.logLevels}
+}
+
+//with return logger which add attrs to every event
+func (l *clientLogger) with(attrs ...any) *clientLogger {
+	if l == nil {
+		return nil
+	}
+	return &clientLogger{Logger: l.Logger.With(attrs...), levels: l.levels}
+}
+
+//log write event if logger is set, level is chosen from levels of logger
+func (l *clientLogger) log(level func(LogLevels) slog.Level, msg string, attrs ...slog.Attr) {
+	if l == nil {
+		return
+	}
+	l.LogAttrs(context.Background(), level(l.levels), msg, attrs...)
+}
+
+func levelConnection(l LogLevels) slog.Level { return l.Connection }
+func levelError(l LogLevels) slog.Level      { return l.Error }
+func levelDrop(l LogLevels) slog.Level       { return l.Drop }
+func levelTimeout(l LogLevels) slog.Level    { return l.Timeout }
+
+//messageAttrs describe message by id, name and code
+func messageAttrs(m *Message, attrs ...slog.Attr) []slog.Attr {
+	return append(attrs, slog.Uint64("id", uint64(m.ID)), slog.String("name", m.Name), slog.Int("code", int(m.Code)))
+}
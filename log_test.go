@@ -0,0 +1,117 @@
+package fdstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//logRecorder collect JSON records of slog logger
+type logRecorder struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(b []byte) (int, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.buf.Write(b)
+}
+
+func (r *logRecorder) logger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(r, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+//find return first record with msg
+func (r *logRecorder) find(msg string) map[string]interface{} {
+	r.l.Lock()
+	defer r.l.Unlock()
+	dec := json.NewDecoder(bytes.NewReader(r.buf.Bytes()))
+	for {
+		var rec map[string]interface{}
+		if dec.Decode(&rec) != nil {
+			return nil
+		}
+		if rec["msg"] == msg {
+			return rec
+		}
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	as := assert.New(t)
+	rec := new(logRecorder)
+	s := &Server{Handler: HandlerFunc(upperHandler), Logger: rec.logger()}
+	a, b := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(b) }()
+
+	cl, err := NewSyncClient(a, a, time.Second)
+	as.Nil(err)
+	_, err = cl.Call(context.Background(), &Message{Name: "n"})
+	as.Nil(err)
+	cl.Shutdown()
+	<-done
+
+	open := rec.find("connection open")
+	as.NotNil(open)
+	as.Equal("INFO", open["level"])
+	as.Equal("pipe", open["peer"])
+	as.NotNil(rec.find("connection closed"))
+}
+
+func TestClientLoggerTimeout(t *testing.T) {
+	as := assert.New(t)
+	rec := new(logRecorder)
+	levels := DefaultLogLevels
+	levels.Timeout = slog.LevelError
+	a, b, err := SyncPipe(50*time.Millisecond, WithClientOptions(WithLogger(rec.logger()), WithLogLevels(levels)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	_, err = a.Call(context.Background(), &Message{Name: "lost"})
+	as.Equal(ErrTimeout, err)
+	timeout := rec.find("call timeout")
+	as.NotNil(timeout)
+	as.Equal("ERROR", timeout["level"])
+	as.Equal("lost", timeout["name"])
+	as.Equal(float64(1), timeout["id"])
+
+	m := <-b.ToReadQ
+	b.ToSendQ <- &Message{ID: m.ID, Name: "late"}
+	as.True(waitFor(func() bool { return rec.find("responce dropped") != nil }))
+	as.Equal("late", rec.find("responce dropped")["name"])
+}
+
+func TestClientLoggerDecodeError(t *testing.T) {
+	as := assert.New(t)
+	rec := new(logRecorder)
+	a, b := net.Pipe()
+	defer b.Close()
+	cl, err := NewAsyncClient(a, a, WithLogger(rec.logger()))
+	as.Nil(err)
+	go b.Write([]byte{0x0, 0, 0, 0, 1, 0x80, 0, 0x0, 0, flagMetadata, 0, 3, 5, 'a', 'b'})
+	<-cl.Done()
+
+	failed := rec.find("read failed")
+	as.NotNil(failed)
+	as.Equal("WARN", failed["level"])
+	as.Equal(ErrBinaryLength.Error(), failed["error"])
+	as.Equal(ErrBinaryLength.Error(), rec.find("connection closed")["error"])
+}
+
+func TestClientWithoutLogger(t *testing.T) {
+	a, b, err := SyncPipe(10 * time.Millisecond)
+	assert.Nil(t, err)
+	defer b.Shutdown()
+	a.Call(context.Background(), &Message{Name: "lost"})
+	a.Shutdown()
+}
@@ -2,10 +2,14 @@ package fdstream
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"io"
+	"os"
 	"reflect"
+	"sort"
 	"sync"
 	"unsafe"
 )
@@ -14,12 +18,45 @@ import (
 
 //Max size of message
 const (
-	MaxMessageSize              = 1e5
-	messageHeaderSize           = 9
+	MaxMessageSize    = 1e5
+	messageHeaderSize = 9
+	//extendedHeaderBit of name length mean that flags byte follow the header
+	extendedHeaderBit uint16 = 1 << 15
+	maxNameLen               = int(extendedHeaderBit - 1)
+
 	erMissRoutingCode      byte = 254
 	erGeneralErrorCode     byte = 255
 	erTimeoutCode          byte = 253
 	erDuplicateIDErrorCode byte = 252
+	erConnectionClosedCode byte = 251
+)
+
+//Codes of error messages, they can be used to choose retryable errors
+const (
+	CodeGeneralError     = erGeneralErrorCode
+	CodeMissRouting      = erMissRoutingCode
+	CodeTimeout          = erTimeoutCode
+	CodeDuplicateID      = erDuplicateIDErrorCode
+	CodeConnectionClosed = erConnectionClosedCode
+)
+
+//Flags of extended header
+const (
+	flagIdempotent byte = 1 << iota
+	//flagChannel mean 2 bytes of channel number follow flags
+	flagChannel
+	//flagMore mean more fragments of message follow
+	flagMore
+	//flagContinuation mean fragment is not first one, it has no name
+	flagContinuation
+	//flagStream mean fragment is a chunk of stream which is read without reassembly
+	flagStream
+	//flagFiles mean 1 byte count of files follow, files are passed as ancillary data of unix socket
+	flagFiles
+	//flagSigned mean signature trailer [key ID length, key ID, HMAC-SHA256] follow payload
+	flagSigned
+	//flagMetadata mean 2 bytes length and metadata block [key length, key, value length(2), value]... follow
+	flagMetadata
 )
 
 var (
@@ -29,6 +66,10 @@ var (
 	ErrTooShortMessage = errors.New("Too short message")
 	//ErrBinaryLength mean rest of bytes have incorrect length according header
 	ErrBinaryLength = errors.New("Incorrect binary length")
+	//ErrNameTooLong mean name could not be encoded in header
+	ErrNameTooLong = errors.New("Too long name of message")
+	//ErrMetadataTooLong mean metadata key, value or whole block could not be encoded
+	ErrMetadataTooLong = errors.New("Too long metadata of message")
 )
 
 //Message is a communication message for async and sync client
@@ -43,6 +84,26 @@ type Message struct {
 	Code byte
 	//Payload is a user data to be send
 	Payload []byte
+	//Idempotent mark request which is safe to execute more than once so it can be retried
+	Idempotent bool
+	//Channel is a logical channel number of message, 0 is a default channel of client
+	Channel uint16
+	//Priority is a class of send queue used by Send, sync and channel clients, it is not sent
+	Priority Priority
+	//Files are passed to peer over unix socket when client use WithFiles option,
+	// sender still own its files, received files should be closed by receiver
+	Files []*os.File
+	//KeyID and Signature are set by Signer, they are sent in trailer so relayed message keep them
+	KeyID     string
+	Signature []byte
+	//Verified is set by client with verifier for message with valid signature
+	Verified bool
+	//Metadata are key value pairs sent with first fragment of message, like traceparent,
+	// they are not covered by signature
+	Metadata map[string]string
+
+	fragment byte            //fragment flags of received or written fragment
+	ctx      context.Context //context of received message, it is not sent
 }
 
 var bufferPool = sync.Pool{}
@@ -64,81 +125,287 @@ func NewMessage(code byte, name string, payload []byte) *Message {
 	}
 }
 
-//Marshal marshal message to byte array with simple structure [code, id,name length, value length, name,value]
-func (m *Message) Marshal() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, m.Len())) //
-	uintNamelen := uint16(len(m.Name))
-	uintValueLen := uint16(len(m.Payload))
+//Context return context of message, for server it carry peer of connection and is done when connection is closed
+func (m *Message) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
 
-	var header [messageHeaderSize]byte
-	header[0] = m.Code
-	binary.BigEndian.PutUint32(header[1:5], m.ID)
-	binary.BigEndian.PutUint16(header[5:7], uintNamelen)
-	binary.BigEndian.PutUint16(header[7:9], uintValueLen)
+//WithContext return shallow copy of message with ctx
+func (m *Message) WithContext(ctx context.Context) *Message {
+	m2 := *m
+	m2.ctx = ctx
+	return &m2
+}
 
-	buf.Write(header[0:9])
-	buf.WriteString(m.Name)
-	buf.Write(m.Payload)
-	res := buf.Bytes()
-	return res, nil
+//flags collect extended header flags of message
+func (m *Message) flags() (f byte) {
+	if m.Idempotent {
+		f |= flagIdempotent
+	}
+	if m.Channel != 0 {
+		f |= flagChannel
+	}
+	if len(m.Files) > 0 {
+		f |= flagFiles
+	}
+	if len(m.Signature) > 0 {
+		f |= flagSigned
+	}
+	if len(m.Metadata) > 0 {
+		f |= flagMetadata
+	}
+	return f | m.fragment
 }
 
-//WriteTo implements io.WriteTo interface to write directly to io.Writer
-func (m *Message) WriteTo(writer io.Writer) (n int64, err error) {
-	buf := getBuf()
+func (m *Message) setFlags(f byte) {
+	m.Idempotent = f&flagIdempotent != 0
+	m.fragment = f & (flagMore | flagContinuation | flagStream)
+}
+
+//extendedLen calculate length of flags and optional fields after header
+func (m *Message) extendedLen() int {
+	flags := m.flags()
+	if flags == 0 {
+		return 0
+	}
+	n := 1
+	if flags&flagChannel != 0 {
+		n += 2
+	}
+	if flags&flagFiles != 0 {
+		n++
+	}
+	if flags&flagMetadata != 0 {
+		n += 2 + m.metadataLen()
+	}
+	return n
+}
+
+//validate check fields of message which could not be encoded except payload length
+func (m *Message) validate() error {
+	if len(m.Name) > maxNameLen {
+		return ErrNameTooLong
+	}
+	if len(m.Files) > maxFiles {
+		return ErrTooManyFiles
+	}
+	if len(m.KeyID) > maxKeyIDLen {
+		return ErrKeyIDTooLong
+	}
+	if len(m.Signature) != 0 && len(m.Signature) != sha256.Size {
+		return ErrSignatureLength
+	}
+	return m.checkMetadata()
+}
+
+//marshalTo write message to buffer with simple structure [code, id, name length, value length, (flags, fields), name, value]
+// flags byte is written only if some flag is set and marked by high bit of name length,
+// optional fields follow flags in order of flag bits
+func (m *Message) marshalTo(buf *bytes.Buffer) error {
+	if len(m.Payload) > maxPayloadLen {
+		return ErrPayloadTooLong
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
 	uintNamelen := uint16(len(m.Name))
 	uintValueLen := uint16(len(m.Payload))
+	flags := m.flags()
+	if flags != 0 {
+		uintNamelen |= extendedHeaderBit
+	}
 
-	var header [messageHeaderSize]byte
+	var header [messageHeaderSize + 6]byte
 	header[0] = m.Code
 	binary.BigEndian.PutUint32(header[1:5], m.ID)
 	binary.BigEndian.PutUint16(header[5:7], uintNamelen)
 	binary.BigEndian.PutUint16(header[7:9], uintValueLen)
 
-	buf.Write(header[0:9])
+	n := messageHeaderSize
+	if flags != 0 {
+		header[n] = flags
+		n++
+	}
+	if flags&flagChannel != 0 {
+		binary.BigEndian.PutUint16(header[n:n+2], m.Channel)
+		n += 2
+	}
+	if flags&flagFiles != 0 {
+		header[n] = byte(len(m.Files))
+		n++
+	}
+	if flags&flagMetadata != 0 {
+		binary.BigEndian.PutUint16(header[n:n+2], uint16(m.metadataLen()))
+		n += 2
+	}
+	buf.Write(header[0:n])
+	if flags&flagMetadata != 0 {
+		m.writeMetadata(buf)
+	}
 	buf.WriteString(m.Name)
 	buf.Write(m.Payload)
+	m.writeTrailer(buf)
+	return nil
+}
 
-	n, err = buf.WriteTo(writer)
+//Marshal marshal message to byte array with simple structure [code, id,name length, value length, name,value]
+func (m *Message) Marshal() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, m.Len())) //
+	if err := m.marshalTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//WriteTo implements io.WriteTo interface to write directly to io.Writer
+func (m *Message) WriteTo(writer io.Writer) (n int64, err error) {
+	buf := getBuf()
+	if err = m.marshalTo(buf); err == nil {
+		n, err = buf.WriteTo(writer)
+	}
+	buf.Reset()
 	bufferPool.Put(buf)
 
 	return n, err
 }
 
-//unmarshal create message from specified byte array or return error
-// for testing performance only
-func unmarshal(b []byte) (m Message, err error) {
-	if len(b) < messageHeaderSize {
-		return m, ErrTooShortMessage
+//readMessage read single message from reader
+// header is a reusable buffer at least messageHeaderSize length
+func readMessage(r io.Reader, header []byte) (*Message, error) {
+	if _, err := io.ReadFull(r, header[:messageHeaderSize]); err != nil {
+		return nil, err
+	}
+	code, id, nameLen, payloadLen := unmarshalHeader(header)
+	m := &Message{
+		Code: code,
+		ID:   id,
+	}
+	var signed bool
+	if nameLen&extendedHeaderBit != 0 {
+		nameLen &^= extendedHeaderBit
+		if _, err := io.ReadFull(r, header[:1]); err != nil {
+			return nil, err
+		}
+		flags := header[0]
+		m.setFlags(flags)
+		signed = flags&flagSigned != 0
+		if flags&flagChannel != 0 {
+			if _, err := io.ReadFull(r, header[:2]); err != nil {
+				return nil, err
+			}
+			m.Channel = binary.BigEndian.Uint16(header[:2])
+		}
+		if flags&flagFiles != 0 {
+			if _, err := io.ReadFull(r, header[:1]); err != nil {
+				return nil, err
+			}
+			m.Files = make([]*os.File, header[0]) //filled by reader from ancillary data
+		}
+		if flags&flagMetadata != 0 {
+			if err := m.readMetadata(r, header); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	var (
-		code               byte
-		cursor, payloadLen uint16
-		ID                 uint32
-	)
+	//Name and payload share one allocation
+	body := make([]byte, int(nameLen)+int(payloadLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if nameLen > 0 {
+		m.Name = dirtyString(body[:nameLen]) //avoid data copy
+	}
+	m.Payload = body[nameLen:]
+	if signed {
+		if err := m.readTrailer(r, header); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
 
-	code, ID, cursor, payloadLen = unmarshalHeader(b)
-	m.Code = code
-	m.ID = ID
-	m.Payload = make([]byte, payloadLen, payloadLen)
+//metadataLen calculate length of encoded metadata block
+func (m *Message) metadataLen() (n int) {
+	for k, v := range m.Metadata {
+		n += 1 + len(k) + 2 + len(v)
+	}
+	return n
+}
 
-	if len(b) != int(messageHeaderSize+cursor+payloadLen) {
-		err = ErrBinaryLength
-		return
+func (m *Message) checkMetadata() error {
+	for k, v := range m.Metadata {
+		if len(k) > 255 || len(v) > 65535 {
+			return ErrMetadataTooLong
+		}
+	}
+	if m.metadataLen() > 65535 {
+		return ErrMetadataTooLong
 	}
+	return nil
+}
 
-	//Cursor is same name len for now
-	if cursor > 0 {
-		m.Name = dirtyString(b[messageHeaderSize : messageHeaderSize+cursor])
+//writeMetadata write pairs in order of keys so same metadata is always encoded same way
+func (m *Message) writeMetadata(buf *bytes.Buffer) {
+	keys := make([]string, 0, len(m.Metadata))
+	for k := range m.Metadata {
+		keys = append(keys, k)
 	}
-	cursor += messageHeaderSize
+	sort.Strings(keys)
+	var l [2]byte
+	for _, k := range keys {
+		v := m.Metadata[k]
+		buf.WriteByte(byte(len(k)))
+		buf.WriteString(k)
+		binary.BigEndian.PutUint16(l[:], uint16(len(v)))
+		buf.Write(l[:])
+		buf.WriteString(v)
+	}
+}
 
-	if payloadLen > 0 {
-		copy(m.Payload, b[cursor:cursor+payloadLen])
+//readMetadata read length and metadata block
+func (m *Message) readMetadata(r io.Reader, header []byte) error {
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return err
 	}
-	return
+	block := make([]byte, binary.BigEndian.Uint16(header[:2]))
+	if _, err := io.ReadFull(r, block); err != nil {
+		return err
+	}
+	m.Metadata = make(map[string]string)
+	for len(block) > 0 {
+		kl := int(block[0])
+		if len(block) < 1+kl+2 {
+			return ErrBinaryLength
+		}
+		k := string(block[1 : 1+kl])
+		vl := int(binary.BigEndian.Uint16(block[1+kl : 3+kl]))
+		block = block[3+kl:]
+		if len(block) < vl {
+			return ErrBinaryLength
+		}
+		m.Metadata[k] = string(block[:vl])
+		block = block[vl:]
+	}
+	return nil
+}
 
+//unmarshal create message from specified byte array or return error
+// for testing performance only
+func unmarshal(b []byte) (m Message, err error) {
+	if len(b) < messageHeaderSize {
+		return m, ErrTooShortMessage
+	}
+
+	r := bytes.NewReader(b)
+	p, err := readMessage(r, make([]byte, messageHeaderSize))
+	if err != nil || r.Len() != 0 {
+		return m, ErrBinaryLength
+	}
+	return *p, nil
 }
 
 //C like function
@@ -162,8 +429,8 @@ func unmarshalHeader(b []byte) (code byte, id uint32, nameLen, payloadLen uint16
 
 //Len calculate current length of message in bytes
 func (m *Message) Len() int {
-	if m != nil {
-		return messageHeaderSize + len(m.Name) + len(m.Payload)
+	if m == nil {
+		return 0
 	}
-	return 0
+	return messageHeaderSize + m.extendedLen() + len(m.Name) + len(m.Payload) + m.trailerLen()
 }
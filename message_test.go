@@ -2,6 +2,7 @@ package fdstream
 
 import (
 	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -130,10 +131,10 @@ func testBenchMarshal(length int, b *testing.B) {
 
 		message *Message
 	}{
-		{"name-5", &Message{string(make([]byte, 5, 5)), 0, 0, payload}},
-		{"name-10", &Message{string(make([]byte, 10, 10)), 0, 0, payload}},
-		{"name-20", &Message{string(make([]byte, 20, 20)), 0, 0, payload}},
-		{"name-50", &Message{string(make([]byte, 50, 50)), 0, 0, payload}},
+		{"name-5", &Message{Name: string(make([]byte, 5, 5)), Payload: payload}},
+		{"name-10", &Message{Name: string(make([]byte, 10, 10)), Payload: payload}},
+		{"name-20", &Message{Name: string(make([]byte, 20, 20)), Payload: payload}},
+		{"name-50", &Message{Name: string(make([]byte, 50, 50)), Payload: payload}},
 	}
 	b.StartTimer()
 	for _, bm := range benchmarks {
@@ -150,7 +151,7 @@ func testBenchUnmarshal(length int, b *testing.B) {
 	payload := make([]byte, length, length)
 
 	stubMessage := func(l int) []byte {
-		z, _ := (&Message{string(make([]byte, l, l)), 0, 0, payload}).Marshal()
+		z, _ := (&Message{Name: string(make([]byte, l, l)), Payload: payload}).Marshal()
 		return z
 	}
 	benchmarks := []struct {
@@ -182,10 +183,10 @@ func testBenchWriteTo(length int, b *testing.B) {
 
 		message *Message
 	}{
-		{"name-5", &Message{string(make([]byte, 5, 5)), 0, 0, payload}},
-		{"name-10", &Message{string(make([]byte, 10, 10)), 0, 0, payload}},
-		{"name-20", &Message{string(make([]byte, 20, 20)), 0, 0, payload}},
-		{"name-50", &Message{string(make([]byte, 50, 50)), 0, 0, payload}},
+		{"name-5", &Message{Name: string(make([]byte, 5, 5)), Payload: payload}},
+		{"name-10", &Message{Name: string(make([]byte, 10, 10)), Payload: payload}},
+		{"name-20", &Message{Name: string(make([]byte, 20, 20)), Payload: payload}},
+		{"name-50", &Message{Name: string(make([]byte, 50, 50)), Payload: payload}},
 	}
 	b.StartTimer()
 	for _, bm := range benchmarks {
@@ -211,3 +212,99 @@ func Benchmark_WriteTo2(b *testing.B)    { testBenchWriteTo(2, b) }
 func Benchmark_WriteTo50(b *testing.B)   { testBenchWriteTo(50, b) }
 func Benchmark_WriteTo500(b *testing.B)  { testBenchWriteTo(500, b) }
 func Benchmark_WriteTo5000(b *testing.B) { testBenchWriteTo(5000, b) }
+
+func Test_marshalFlags(t *testing.T) {
+	m := &Message{Code: 1, ID: 7, Name: "name", Payload: []byte("value"), Idempotent: true}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Message.Marshal() error = %v", err)
+	}
+	want := append([]byte{0x1, 0, 0, 0, 7, 0x80, 4, 0x0, 5, flagIdempotent}, []byte(`namevalue`)...)
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("Message.Marshal() = %v, want %v", b, want)
+	}
+	if len(b) != m.Len() {
+		t.Errorf("Message.Len() = %d, want %d", m.Len(), len(b))
+	}
+
+	got, err := unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, *m) {
+		t.Errorf("Unmarshal() = %v, want %v", got, *m)
+	}
+
+	if _, err = (&Message{Name: string(make([]byte, maxNameLen+1))}).Marshal(); err != ErrNameTooLong {
+		t.Errorf("Message.Marshal() error = %v, want %v", err, ErrNameTooLong)
+	}
+}
+
+func Test_marshalChannel(t *testing.T) {
+	m := &Message{ID: 1, Name: "n", Payload: []byte("v"), Channel: 258}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Message.Marshal() error = %v", err)
+	}
+	want := append([]byte{0x0, 0, 0, 0, 1, 0x80, 1, 0x0, 1, flagChannel, 1, 2}, []byte(`nv`)...)
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("Message.Marshal() = %v, want %v", b, want)
+	}
+	got, err := unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, *m) {
+		t.Errorf("Unmarshal() = %v, want %v", got, *m)
+	}
+}
+
+func Test_marshalFiles(t *testing.T) {
+	m := &Message{ID: 1, Name: "n", Channel: 258, Files: []*os.File{os.Stdin, os.Stdout}}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Message.Marshal() error = %v", err)
+	}
+	want := append([]byte{0x0, 0, 0, 0, 1, 0x80, 1, 0x0, 0, flagChannel | flagFiles, 1, 2, 2}, []byte(`n`)...)
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("Message.Marshal() = %v, want %v", b, want)
+	}
+	got, err := unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(got.Files) != 2 || got.Files[0] != nil {
+		t.Errorf("Unmarshal() files = %v, want 2 empty slots", got.Files)
+	}
+}
+
+func Test_marshalMetadata(t *testing.T) {
+	m := &Message{ID: 1, Name: "n", Payload: []byte("v"), Metadata: map[string]string{"b": "2", "a": "1"}}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Message.Marshal() error = %v", err)
+	}
+	want := append([]byte{0x0, 0, 0, 0, 1, 0x80, 1, 0x0, 1, flagMetadata, 0, 10, 1, 'a', 0, 1, '1', 1, 'b', 0, 1, '2'}, []byte(`nv`)...)
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("Message.Marshal() = %v, want %v", b, want)
+	}
+	if len(b) != m.Len() {
+		t.Errorf("Message.Len() = %d, want %d", m.Len(), len(b))
+	}
+	got, err := unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, *m) {
+		t.Errorf("Unmarshal() = %v, want %v", got, *m)
+	}
+
+	long := &Message{Metadata: map[string]string{string(make([]byte, 256)): ""}}
+	if _, err = long.Marshal(); err != ErrMetadataTooLong {
+		t.Errorf("Message.Marshal() error = %v, want %v", err, ErrMetadataTooLong)
+	}
+	broken := []byte{0x0, 0, 0, 0, 1, 0x80, 0, 0x0, 0, flagMetadata, 0, 3, 5, 'a', 'b'}
+	if _, err = unmarshal(broken); err == nil {
+		t.Errorf("Unmarshal() of broken metadata should fail")
+	}
+}
@@ -0,0 +1,154 @@
+package fdstream
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//StatsSource is a client or server which stats are exported by Registry
+type StatsSource interface {
+	Stats() Stats
+}
+
+//Registry keep named clients and servers and export their stats,
+// it is an http.Handler which render stats in Prometheus text format
+type Registry struct {
+	l       sync.Mutex
+	sources map[string]StatsSource
+}
+
+//DefaultRegistry is used by Register and Unregister
+var DefaultRegistry = NewRegistry()
+
+//NewRegistry create empty registry
+func NewRegistry() *Registry {
+	return &Registry{sources: make(map[string]StatsSource)}
+}
+
+//Register add source to default registry
+func Register(name string, s StatsSource) {
+	DefaultRegistry.Register(name, s)
+}
+
+//Unregister remove source from default registry
+func Unregister(name string) {
+	DefaultRegistry.Unregister(name)
+}
+
+//Register add source with name, source with same name is replaced
+func (r *Registry) Register(name string, s StatsSource) {
+	r.l.Lock()
+	r.sources[name] = s
+	r.l.Unlock()
+}
+
+//Unregister remove source by name
+func (r *Registry) Unregister(name string) {
+	r.l.Lock()
+	delete(r.sources, name)
+	r.l.Unlock()
+}
+
+//Snapshot return stats of all sources by name
+func (r *Registry) Snapshot() map[string]Stats {
+	r.l.Lock()
+	sources := make(map[string]StatsSource, len(r.sources))
+	for name, s := range r.sources {
+		sources[name] = s
+	}
+	r.l.Unlock()
+
+	stats := make(map[string]Stats, len(sources))
+	for name, s := range sources {
+		stats[name] = s.Stats()
+	}
+	return stats
+}
+
+//Publish export snapshot of registry as expvar variable, like expvar.Publish it panic if name is already used
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Snapshot()
+	}))
+}
+
+//promMetric describe single value of Stats
+type promMetric struct {
+	name, kind, help string
+	value            func(s *Stats) float64
+}
+
+var promMetrics = []promMetric{
+	{"fdstream_messages_sent_total", "counter", "Messages written to connection.", func(s *Stats) float64 { return float64(s.MessagesSent) }},
+	{"fdstream_sent_bytes_total", "counter", "Bytes written to connection.", func(s *Stats) float64 { return float64(s.BytesSent) }},
+	{"fdstream_messages_received_total", "counter", "Messages read from connection.", func(s *Stats) float64 { return float64(s.MessagesReceived) }},
+	{"fdstream_received_bytes_total", "counter", "Bytes read from connection.", func(s *Stats) float64 { return float64(s.BytesReceived) }},
+	{"fdstream_send_queue", "gauge", "Messages waiting in send queues.", func(s *Stats) float64 { return float64(s.SendQueue) }},
+	{"fdstream_read_queue", "gauge", "Messages waiting in read queue.", func(s *Stats) float64 { return float64(s.ReadQueue) }},
+	{"fdstream_calls_total", "counter", "Finished sync calls.", func(s *Stats) float64 { return float64(s.Calls) }},
+	{"fdstream_calls_in_flight", "gauge", "Sync calls waiting for responce.", func(s *Stats) float64 { return float64(s.InFlight) }},
+	{"fdstream_call_timeouts_total", "counter", "Sync calls finished by timeout.", func(s *Stats) float64 { return float64(s.Timeouts) }},
+	{"fdstream_duplicate_ids_total", "counter", "Sync calls rejected because of duplicate ID.", func(s *Stats) float64 { return float64(s.DuplicateIDs) }},
+	{"fdstream_orphaned_responces", "gauge", "Received responces which nobody wait yet.", func(s *Stats) float64 { return float64(s.Orphaned) }},
+}
+
+//ServeHTTP render stats of all sources in Prometheus text format, source name is a label
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+//WriteText write stats of all sources in Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	stats := r.Snapshot()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := bufio.NewWriter(w)
+	for _, m := range promMetrics {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, name := range names {
+			s := stats[name]
+			fmt.Fprintf(b, "%s{name=%s} %s\n", m.name, quoteLabel(name), formatFloat(m.value(&s)))
+		}
+	}
+
+	const latency = "fdstream_call_latency_seconds"
+	fmt.Fprintf(b, "# HELP %s Latency of sync calls.\n# TYPE %s histogram\n", latency, latency)
+	for _, name := range names {
+		h := stats[name].Latency
+		if h.Counts == nil { //async client has no calls
+			h = Histogram{Bounds: latencyBounds, Counts: make([]uint64, len(latencyBounds)+1)}
+		}
+		label := quoteLabel(name)
+		var cumulative uint64
+		for i, bound := range h.Bounds {
+			cumulative += h.Counts[i]
+			fmt.Fprintf(b, "%s_bucket{name=%s,le=\"%s\"} %d\n", latency, label, formatFloat(bound.Seconds()), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{name=%s,le=\"+Inf\"} %d\n", latency, label, h.Count)
+		fmt.Fprintf(b, "%s_sum{name=%s} %s\n", latency, label, formatFloat(h.Sum.Seconds()))
+		fmt.Fprintf(b, "%s_count{name=%s} %d\n", latency, label, h.Count)
+	}
+	return b.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package fdstream
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryPrometheus(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(time.Second)
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	go echoPeer(b)
+	_, err = a.Call(context.Background(), &Message{Name: "ping"})
+	as.Nil(err)
+
+	r := NewRegistry()
+	r.Register(`sync "a"`, a)
+	r.Register("async", b)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	as.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	as.Contains(body, "# TYPE fdstream_calls_total counter\n")
+	as.Contains(body, `fdstream_calls_total{name="sync \"a\""} 1`+"\n")
+	as.Contains(body, `fdstream_calls_total{name="async"} 0`+"\n")
+	as.Contains(body, `fdstream_messages_received_total{name="async"} 1`+"\n")
+	as.Contains(body, "# TYPE fdstream_call_latency_seconds histogram\n")
+	as.Contains(body, `fdstream_call_latency_seconds_bucket{name="sync \"a\"",le="10"} 1`+"\n")
+	as.Contains(body, `fdstream_call_latency_seconds_bucket{name="async",le="+Inf"} 0`+"\n")
+	as.Contains(body, `fdstream_call_latency_seconds_count{name="sync \"a\""} 1`+"\n")
+	as.True(strings.Index(body, `name="async"`) < strings.Index(body, `name="sync`))
+
+	r.Unregister("async")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	as.NotContains(w.Body.String(), `name="async"`)
+}
+
+//expvarRegistry is published once, expvar does not allow to publish same name again
+var (
+	expvarRegistry = NewRegistry()
+	expvarOnce     sync.Once
+)
+
+func TestRegistryExpvar(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := Pipe()
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	a.ToSendQ <- &Message{Name: "one"}
+	<-b.ToReadQ
+
+	expvarOnce.Do(func() { expvarRegistry.Publish("fdstream_test_registry") })
+	expvarRegistry.Register("b", b)
+	defer expvarRegistry.Unregister("b")
+
+	var got map[string]Stats
+	as.Nil(json.Unmarshal([]byte(expvar.Get("fdstream_test_registry").String()), &got))
+	as.Equal(uint64(1), got["b"].MessagesReceived)
+}
+
+func TestServerStats(t *testing.T) {
+	as := assert.New(t)
+	s := &Server{Handler: HandlerFunc(upperHandler)}
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		a, b := net.Pipe()
+		go s.ServeConn(b)
+		cl, err := NewSyncClient(a, a, time.Second)
+		as.Nil(err)
+		_, err = cl.Call(context.Background(), &Message{Name: "ping"})
+		as.Nil(err)
+		if i == 0 {
+			cl.Shutdown()
+		} else {
+			defer cl.Shutdown()
+		}
+	}
+	as.True(waitFor(func() bool {
+		st := s.Stats()
+		return st.MessagesReceived == 2 && st.MessagesSent == 2
+	}))
+}
@@ -0,0 +1,308 @@
+package fdstream
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Strategy is a way MultiClient select endpoint for a call
+type Strategy int
+
+//Endpoint selection strategies
+const (
+	//RoundRobin select healthy endpoints one by one
+	RoundRobin Strategy = iota
+	//LeastLoaded select healthy endpoint with least in-flight calls
+	LeastLoaded
+	//HashByName select endpoint by consistent hash of message name
+	HashByName
+)
+
+const (
+	defaultEjectFor     = 5 * time.Second
+	defaultResolveEvery = 30 * time.Second
+	defaultAttempts     = 2
+	hashReplicas        = 64
+)
+
+//ErrNoEndpoints is returned when there is no endpoint to send message to
+var ErrNoEndpoints = errors.New("No endpoints available")
+
+//Resolver return actual list of endpoint addresses
+type Resolver func(ctx context.Context) ([]string, error)
+
+//StaticResolver return resolver for fixed list of addresses
+func StaticResolver(addrs ...string) Resolver {
+	return func(context.Context) ([]string, error) {
+		return addrs, nil
+	}
+}
+
+//AddrDialer open new transport to specified address
+type AddrDialer func(ctx context.Context, addr string) (io.ReadWriteCloser, error)
+
+//BalancePolicy describe how MultiClient select endpoints and react on their failures
+// zero value is usable and mean defaults
+type BalancePolicy struct {
+	//Strategy of endpoint selection, default RoundRobin
+	Strategy Strategy
+	//EjectFor is a time endpoint is skipped after error or timeout, default 5s
+	EjectFor time.Duration
+	//Attempts is a max count of endpoints tried for idempotent call, default 2
+	Attempts int
+	//ResolveEvery is a period of endpoint list refresh, default 30s
+	ResolveEvery time.Duration
+	//Reconnect is a policy for connection to every endpoint
+	Reconnect ReconnectPolicy
+}
+
+type endpoint struct {
+	addr         string
+	client       *ReconnectingClient
+	ejectedUntil int64
+}
+
+func (e *endpoint) connected() bool {
+	return e.client.State() == StateConnected
+}
+
+func (e *endpoint) healthy(now int64) bool {
+	return e.connected() && atomic.LoadInt64(&e.ejectedUntil) <= now
+}
+
+type ringPoint struct {
+	hash uint32
+	e    *endpoint
+}
+
+//MultiClient send calls to several endpoints with load balancing and failover
+// endpoints which fail or time out are ejected for a while, idempotent calls are retried on another endpoint
+type MultiClient struct {
+	resolve Resolver
+	dial    AddrDialer
+	timeout time.Duration
+	policy  BalancePolicy
+	next    uint32
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	l         sync.RWMutex
+	endpoints []*endpoint
+	ring      []ringPoint
+}
+
+//NewMultiClient resolve endpoints and connect to them in background
+// timeout is a message timeout for every endpoint connection
+func NewMultiClient(resolve Resolver, dial AddrDialer, timeout time.Duration, policy BalancePolicy) (*MultiClient, error) {
+	if policy.EjectFor <= 0 {
+		policy.EjectFor = defaultEjectFor
+	}
+	if policy.Attempts <= 0 {
+		policy.Attempts = defaultAttempts
+	}
+	if policy.ResolveEvery <= 0 {
+		policy.ResolveEvery = defaultResolveEvery
+	}
+	c := &MultiClient{
+		resolve: resolve,
+		dial:    dial,
+		timeout: timeout,
+		policy:  policy,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if err := c.refresh(); err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	go c.resolveWorker()
+	return c, nil
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+//refresh resolve addresses, connect new endpoints and close removed
+func (c *MultiClient) refresh() error {
+	addrs, err := c.resolve(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.ctx.Err() != nil { //closed during resolve
+		return c.ctx.Err()
+	}
+	old := make(map[string]*endpoint, len(c.endpoints))
+	for _, e := range c.endpoints {
+		old[e.addr] = e
+	}
+	endpoints := make([]*endpoint, 0, len(addrs))
+	ring := make([]ringPoint, 0, len(addrs)*hashReplicas)
+	for _, addr := range addrs {
+		e, ok := old[addr]
+		if ok {
+			delete(old, addr)
+		} else if e = c.newEndpoint(addr, endpoints); e == nil {
+			continue //duplicate address
+		}
+		endpoints = append(endpoints, e)
+		for i := 0; i < hashReplicas; i++ {
+			ring = append(ring, ringPoint{hash: hashString(addr + "#" + strconv.Itoa(i)), e: e})
+		}
+	}
+	for _, e := range old {
+		e.client.Close()
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	c.endpoints, c.ring = endpoints, ring
+	return nil
+}
+
+func (c *MultiClient) newEndpoint(addr string, endpoints []*endpoint) *endpoint {
+	for _, e := range endpoints {
+		if e.addr == addr {
+			return nil
+		}
+	}
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return c.dial(ctx, addr)
+	}
+	return &endpoint{addr: addr, client: NewReconnectingClient(dial, c.timeout, c.policy.Reconnect)}
+}
+
+func (c *MultiClient) resolveWorker() {
+	ticker := time.NewTicker(c.policy.ResolveEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh() //keep previous endpoints on resolve error
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+//pick select endpoint for message which is not tried yet
+// ejected endpoints are used only when there is no healthy one,
+// with BufferCalls not connected endpoint is used last so call wait for its connection
+func (c *MultiClient) pick(m *Message, tried map[*endpoint]bool) *endpoint {
+	now := time.Now().UnixNano()
+	c.l.RLock()
+	defer c.l.RUnlock()
+	e := c.pickBy(m, func(e *endpoint) bool { return !tried[e] && e.healthy(now) })
+	if e == nil {
+		e = c.pickBy(m, func(e *endpoint) bool { return !tried[e] && e.connected() })
+	}
+	if e == nil && c.policy.Reconnect.BufferCalls {
+		e = c.pickBy(m, func(e *endpoint) bool { return !tried[e] && e.client.State() != StateClosed })
+	}
+	return e
+}
+
+func (c *MultiClient) pickBy(m *Message, usable func(*endpoint) bool) *endpoint {
+	n := len(c.endpoints)
+	if n == 0 {
+		return nil
+	}
+	switch c.policy.Strategy {
+	case HashByName:
+		h := hashString(m.Name)
+		start := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+		for i := 0; i < len(c.ring); i++ {
+			if p := c.ring[(start+i)%len(c.ring)]; usable(p.e) {
+				return p.e
+			}
+		}
+	case LeastLoaded:
+		var (
+			best     *endpoint
+			bestLoad int
+			start    = int(atomic.AddUint32(&c.next, 1))
+		)
+		for i := 0; i < n; i++ {
+			e := c.endpoints[(start+i)%n]
+			if !usable(e) {
+				continue
+			}
+			if load := e.client.InFlight(); best == nil || load < bestLoad {
+				best, bestLoad = e, load
+			}
+		}
+		return best
+	default:
+		start := int(atomic.AddUint32(&c.next, 1))
+		for i := 0; i < n; i++ {
+			if e := c.endpoints[(start+i)%n]; usable(e) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (c *MultiClient) eject(e *endpoint) {
+	atomic.StoreInt64(&e.ejectedUntil, time.Now().Add(c.policy.EjectFor).UnixNano())
+}
+
+//isConnectionError check that error is caused by endpoint and not by responce
+func isConnectionError(err error) bool {
+	return err == ErrTimeout || err == ErrConnectionClosed || err == ErrNotConnected
+}
+
+//Call write message to selected endpoint and wait responce
+// on connection error or timeout endpoint is ejected and idempotent call is retried on another endpoint,
+// not idempotent call is retried only if it was not sent
+func (c *MultiClient) Call(ctx context.Context, m *Message) (*Message, error) {
+	var (
+		tried   = make(map[*endpoint]bool, c.policy.Attempts)
+		lastErr = ErrNoEndpoints
+	)
+	for attempt := 0; attempt < c.policy.Attempts; attempt++ {
+		e := c.pick(m, tried)
+		if e == nil {
+			break
+		}
+		tried[e] = true
+		req := *m //previous attempt could still be in send queue
+		resp, err := e.client.Call(ctx, &req)
+		if err == nil || !isConnectionError(err) {
+			return resp, err
+		}
+		c.eject(e)
+		lastErr = err
+		if ctx.Err() != nil || !(m.Idempotent || err == ErrNotConnected) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+//WriteAndReadResponce will write message and expect responce or error
+func (c *MultiClient) WriteAndReadResponce(m *Message) (*Message, error) {
+	return c.Call(context.Background(), m)
+}
+
+//Close stop resolving and shutdown all endpoint connections
+func (c *MultiClient) Close() error {
+	c.cancel()
+	c.l.Lock()
+	for _, e := range c.endpoints {
+		e.client.Close()
+	}
+	c.endpoints, c.ring = nil, nil
+	c.l.Unlock()
+	return nil
+}
@@ -0,0 +1,144 @@
+package fdstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//serveTagged answer every message with payload equal to tag, silent server never answer
+func serveTagged(rw io.ReadWriteCloser, tag string, silent bool) {
+	cl, _ := NewAsyncClient(rw, rw)
+	for {
+		select {
+		case m := <-cl.ToReadQ:
+			if !silent {
+				cl.ToSendQ <- &Message{ID: m.ID, Name: m.Name, Payload: []byte(tag)}
+			}
+		case <-cl.Done():
+			return
+		}
+	}
+}
+
+func taggedDialer(silent ...string) AddrDialer {
+	return func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		isSilent := false
+		for _, s := range silent {
+			isSilent = isSilent || s == addr
+		}
+		go serveTagged(server, addr, isSilent)
+		return client, nil
+	}
+}
+
+func newTestMultiClient(t *testing.T, policy BalancePolicy, timeout time.Duration, silent ...string) *MultiClient {
+	policy.Reconnect.MinBackoff = time.Millisecond
+	policy.Reconnect.BufferCalls = true //calls wait for endpoints which are connecting
+	c, err := NewMultiClient(StaticResolver("a", "b", "c"), taggedDialer(silent...), timeout, policy)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestMultiClientRoundRobin(t *testing.T) {
+	as := assert.New(t)
+	c := newTestMultiClient(t, BalancePolicy{}, time.Second)
+	defer c.Close()
+
+	hits := map[string]int{}
+	for i := 0; i < 6; i++ {
+		m, err := c.WriteAndReadResponce(&Message{Name: "rr" + strconv.Itoa(i)})
+		as.Nil(err)
+		hits[string(m.Payload)]++
+	}
+	as.Equal(map[string]int{"a": 2, "b": 2, "c": 2}, hits)
+}
+
+func TestMultiClientHashByName(t *testing.T) {
+	as := assert.New(t)
+	c := newTestMultiClient(t, BalancePolicy{Strategy: HashByName}, time.Second)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		name := "key" + strconv.Itoa(i)
+		first, err := c.WriteAndReadResponce(&Message{Name: name})
+		as.Nil(err)
+		for j := 0; j < 3; j++ {
+			m, err := c.WriteAndReadResponce(&Message{Name: name})
+			as.Nil(err)
+			as.Equal(first.Payload, m.Payload)
+		}
+	}
+}
+
+func TestMultiClientLeastLoaded(t *testing.T) {
+	as := assert.New(t)
+	c := newTestMultiClient(t, BalancePolicy{Strategy: LeastLoaded}, time.Second)
+	defer c.Close()
+
+	atomic.StoreInt32(&c.endpoints[0].client.inFlight, 3)
+	atomic.StoreInt32(&c.endpoints[2].client.inFlight, 2)
+	m, err := c.WriteAndReadResponce(&Message{Name: "load"})
+	as.Nil(err)
+	as.Equal([]byte("b"), m.Payload)
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	as := assert.New(t)
+	c := newTestMultiClient(t, BalancePolicy{Attempts: 3}, 30*time.Millisecond, "a", "b")
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		m, err := c.WriteAndReadResponce(&Message{Name: "idempotent" + strconv.Itoa(i), Idempotent: true})
+		as.Nil(err)
+		as.Equal([]byte("c"), m.Payload)
+	}
+	now := time.Now().UnixNano()
+	as.False(c.endpoints[0].healthy(now))
+	as.False(c.endpoints[1].healthy(now))
+	as.True(c.endpoints[2].healthy(now))
+}
+
+func TestMultiClientNotIdempotent(t *testing.T) {
+	as := assert.New(t)
+	c := newTestMultiClient(t, BalancePolicy{Attempts: 3}, 30*time.Millisecond, "a", "b", "c")
+	defer c.Close()
+
+	_, err := c.WriteAndReadResponce(&Message{Name: "once"})
+	as.Equal(ErrTimeout, err)
+	ejected := 0
+	for _, e := range c.endpoints {
+		if e.ejectedUntil > 0 {
+			ejected++
+		}
+	}
+	as.Equal(1, ejected)
+}
+
+func TestMultiClientBufferCalls(t *testing.T) {
+	as := assert.New(t)
+	dial := taggedDialer()
+	slowDial := func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		time.Sleep(50 * time.Millisecond)
+		return dial(ctx, addr)
+	}
+	c, err := NewMultiClient(StaticResolver("a"), slowDial, time.Second, BalancePolicy{})
+	as.Nil(err)
+	_, err = c.WriteAndReadResponce(&Message{Name: "early"})
+	as.Equal(ErrNoEndpoints, err)
+	c.Close()
+
+	c, err = NewMultiClient(StaticResolver("a"), slowDial, time.Second, BalancePolicy{Reconnect: ReconnectPolicy{BufferCalls: true}})
+	as.Nil(err)
+	defer c.Close()
+	m, err := c.WriteAndReadResponce(&Message{Name: "early"})
+	as.Nil(err)
+	as.Equal([]byte("a"), m.Payload)
+}
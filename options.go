@@ -0,0 +1,82 @@
+package fdstream
+
+import (
+	"log/slog"
+	"time"
+)
+
+//Option configure client created by NewAsyncClient or NewSyncClient
+type Option func(*config)
+
+type config struct {
+	flowMessages    int
+	flowBytes       int
+	fragmentSize    int
+	reassemblyLimit int
+	files           bool
+	callTimeout     time.Duration
+	psk             []byte
+	helloName       string
+	helloTimeout    time.Duration
+	authenticator   Authenticator
+	credentials     Credentials
+	signer          *Signer
+	keyring         Keyring
+	verifyMode      VerifyMode
+	metrics         MetricsSink
+	tracer          Tracer
+	interceptors    []ClientInterceptor
+	logger          *slog.Logger
+	logLevels       LogLevels
+	capture         *CaptureWriter
+	serveChannels   bool
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		fragmentSize:    defaultFragmentSize,
+		reassemblyLimit: defaultReassemblyLimit,
+		callTimeout:     defaultCallTimeout,
+		logLevels:       DefaultLogLevels,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+//WithFlowControl enable credit based flow control of default channel
+// peer can send only messages and bytes (0 mean no limit) which were not read from ToReadQ yet,
+// both sides of connection should use the option with same values
+func WithFlowControl(messages, bytes int) Option {
+	return func(cfg *config) {
+		if messages <= 0 {
+			messages = defaultQSize
+		}
+		if bytes < 0 {
+			bytes = 0
+		}
+		cfg.flowMessages, cfg.flowBytes = messages, bytes
+	}
+}
+
+//WithFragmentSize set payload size of fragments, bigger payloads are split and interleaved with other messages,
+// size is limited by 65535 bytes, it is a default size
+func WithFragmentSize(size int) Option {
+	return func(cfg *config) {
+		if size <= 0 || size > maxPayloadLen {
+			size = maxPayloadLen
+		}
+		cfg.fragmentSize = size
+	}
+}
+
+//WithReassemblyLimit set memory limit in bytes for incomplete fragmented messages of connection,
+// connection is closed with ErrReassemblyLimit when peer exceed it, default is 64MB
+func WithReassemblyLimit(bytes int) Option {
+	return func(cfg *config) {
+		if bytes > 0 {
+			cfg.reassemblyLimit = bytes
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package fdstream
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+//defaultPipeBuffer is a count of bytes written to pipe before writer wait reader
+const defaultPipeBuffer = 64 << 10
+
+//PipeOption configure in-memory connection created by Pipe or SyncPipe
+type PipeOption func(*pipeConfig)
+
+type pipeConfig struct {
+	latency   time.Duration
+	bandwidth int
+	buffer    int
+	options   []Option
+	peer      []Option //options of second client, nil mean same options
+}
+
+//WithLatency delay delivery of every write by d
+func WithLatency(d time.Duration) PipeOption {
+	return func(cfg *pipeConfig) {
+		cfg.latency = d
+	}
+}
+
+//WithBandwidth limit speed of every direction by bytes per second, zero mean no limit
+func WithBandwidth(bytesPerSecond int) PipeOption {
+	return func(cfg *pipeConfig) {
+		cfg.bandwidth = bytesPerSecond
+	}
+}
+
+//WithPipeBuffer set count of bytes in flight of every direction, default 64KB
+func WithPipeBuffer(bytes int) PipeOption {
+	return func(cfg *pipeConfig) {
+		if bytes > 0 {
+			cfg.buffer = bytes
+		}
+	}
+}
+
+//WithClientOptions set options of both clients of pipe
+func WithClientOptions(opts ...Option) PipeOption {
+	return func(cfg *pipeConfig) {
+		cfg.options = opts
+	}
+}
+
+//WithPeerOptions set options of second client of pipe, like authenticator for credentials of first one
+func WithPeerOptions(opts ...Option) PipeOption {
+	return func(cfg *pipeConfig) {
+		cfg.peer = opts
+	}
+}
+
+//Pipe create two async clients connected by in-memory buffered pipes, it is useful for tests
+func Pipe(opts ...PipeOption) (a, b *AsyncClient, err error) {
+	cfg := newPipeConfig(opts)
+	b, err = connectPipe(cfg, func(left *pipeConn) (err error) {
+		a, err = NewAsyncClient(left, left, cfg.options...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+//SyncPipe create sync client connected by in-memory buffered pipes to async peer,
+// test read requests from ToReadQ of peer and answer them
+func SyncPipe(timeout time.Duration, opts ...PipeOption) (a *SyncClient, b *AsyncClient, err error) {
+	cfg := newPipeConfig(opts)
+	b, err = connectPipe(cfg, func(left *pipeConn) (err error) {
+		a, err = NewSyncClient(left, left, timeout, cfg.options...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+//connectPipe create first client by connect and second one concurrently, so authentication could be finished,
+// side which failed close its end and created client is shut down on error
+func connectPipe(cfg pipeConfig, connect func(left *pipeConn) error) (*AsyncClient, error) {
+	left, right := newPipeConn(cfg)
+	var (
+		b    *AsyncClient
+		errB error
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		if b, errB = NewAsyncClient(right, right, cfg.peer...); errB != nil {
+			right.Close()
+		}
+	}()
+	err := connect(left)
+	if err != nil {
+		left.Close()
+	}
+	<-done
+	if err == nil {
+		err = errB
+	}
+	if err != nil {
+		left.Close()
+		if b != nil {
+			b.Shutdown()
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func newPipeConfig(opts []PipeOption) pipeConfig {
+	cfg := pipeConfig{buffer: defaultPipeBuffer}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.peer == nil {
+		cfg.peer = cfg.options
+	}
+	return cfg
+}
+
+//pipeChunk is a written data which can be read after ready time
+type pipeChunk struct {
+	data  []byte
+	ready time.Time
+}
+
+//memPipe is a single direction of in-memory connection
+type memPipe struct {
+	cfg pipeConfig
+
+	l        sync.Mutex
+	chunks   []pipeChunk
+	size     int
+	linkFree time.Time //time when previous write is transmitted with bandwidth limit
+	closed   bool
+	notify   chan struct{} //closed on every change
+}
+
+func newMemPipe(cfg pipeConfig) *memPipe {
+	return &memPipe{cfg: cfg, notify: make(chan struct{})}
+}
+
+//changed wake up waiters, it should be called under lock
+func (p *memPipe) changed() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+func (p *memPipe) Write(b []byte) (int, error) {
+	p.l.Lock()
+	for {
+		if p.closed {
+			p.l.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if p.size == 0 || p.size+len(b) <= p.cfg.buffer {
+			break
+		}
+		wait := p.notify
+		p.l.Unlock()
+		<-wait
+		p.l.Lock()
+	}
+	now := time.Now()
+	sent := now
+	if p.cfg.bandwidth > 0 {
+		if p.linkFree.After(now) {
+			sent = p.linkFree
+		}
+		sent = sent.Add(time.Duration(len(b)) * time.Second / time.Duration(p.cfg.bandwidth))
+		p.linkFree = sent
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	p.chunks = append(p.chunks, pipeChunk{data: data, ready: sent.Add(p.cfg.latency)})
+	p.size += len(b)
+	p.changed()
+	p.l.Unlock()
+	return len(b), nil
+}
+
+func (p *memPipe) Read(b []byte) (int, error) {
+	p.l.Lock()
+	for {
+		if len(p.chunks) == 0 {
+			if p.closed {
+				p.l.Unlock()
+				return 0, io.EOF
+			}
+			wait := p.notify
+			p.l.Unlock()
+			<-wait
+			p.l.Lock()
+			continue
+		}
+		delay := time.Until(p.chunks[0].ready)
+		if delay <= 0 {
+			break
+		}
+		wait := p.notify
+		p.l.Unlock()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-wait:
+			timer.Stop()
+		}
+		p.l.Lock()
+	}
+	chunk := &p.chunks[0]
+	n := copy(b, chunk.data)
+	if chunk.data = chunk.data[n:]; len(chunk.data) == 0 {
+		p.chunks = p.chunks[1:]
+	}
+	p.size -= n
+	p.changed()
+	p.l.Unlock()
+	return n, nil
+}
+
+//Close stop writes, reader get rest of data and io.EOF
+func (p *memPipe) Close() error {
+	return p.close(false)
+}
+
+//close mark pipe closed, reader side drop data which is not read yet
+func (p *memPipe) close(drop bool) error {
+	p.l.Lock()
+	if drop {
+		p.chunks, p.size = nil, 0
+	}
+	if !p.closed || drop {
+		p.closed = true
+		p.changed()
+	}
+	p.l.Unlock()
+	return nil
+}
+
+//pipeConn is an end of in-memory connection
+type pipeConn struct {
+	r, w *memPipe
+}
+
+func newPipeConn(cfg pipeConfig) (*pipeConn, *pipeConn) {
+	ab, ba := newMemPipe(cfg), newMemPipe(cfg)
+	return &pipeConn{r: ba, w: ab}, &pipeConn{r: ab, w: ba}
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+//Close close both directions, so peer get io.EOF
+func (c *pipeConn) Close() error {
+	c.w.Close()
+	return c.r.close(true)
+}
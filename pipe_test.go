@@ -0,0 +1,110 @@
+package fdstream
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//echoPeer answer every message with same id, name and payload until client is closed
+func echoPeer(c *AsyncClient) {
+	for {
+		select {
+		case m := <-c.ToReadQ:
+			select {
+			case c.ToSendQ <- &Message{ID: m.ID, Name: m.Name, Payload: m.Payload}:
+			case <-c.Done():
+				return
+			}
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+func TestSyncPipe(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(time.Second)
+	as.Nil(err)
+	defer a.Shutdown()
+	go echoPeer(b)
+
+	m, err := a.WriteAndReadResponce(&Message{Name: "ping", Payload: []byte("pong")})
+	as.Nil(err)
+	as.Equal([]byte("pong"), m.Payload)
+
+	b.Shutdown()
+	<-a.Done()
+	as.Equal(io.EOF, a.Err())
+}
+
+func TestPipeLatency(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(time.Second, WithLatency(30*time.Millisecond))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	go echoPeer(b)
+
+	start := time.Now()
+	_, err = a.WriteAndReadResponce(&Message{Name: "slow"})
+	as.Nil(err)
+	as.True(time.Since(start) >= 60*time.Millisecond)
+}
+
+func TestPipeBandwidth(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := Pipe(WithBandwidth(100<<10), WithClientOptions(WithFragmentSize(1024)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	start := time.Now()
+	a.ToSendQ <- &Message{Name: "big", Payload: make([]byte, 20<<10)}
+	m := <-b.ToReadQ
+	as.Len(m.Payload, 20<<10)
+	as.True(time.Since(start) >= 190*time.Millisecond)
+}
+
+func TestPipeBuffer(t *testing.T) {
+	as := assert.New(t)
+	left, right := newPipeConn(newPipeConfig([]PipeOption{WithPipeBuffer(4)}))
+
+	n, err := left.Write([]byte("0123456789")) //bigger than buffer is allowed for empty pipe
+	as.Equal(10, n)
+	as.Nil(err)
+	written := make(chan struct{})
+	go func() {
+		left.Write([]byte("ab"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write should wait for reader")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(right, buf)
+	as.Nil(err)
+	<-written
+	right.Close()
+	_, err = left.Write([]byte("c"))
+	as.Equal(io.ErrClosedPipe, err)
+}
+
+func TestPipeAuth(t *testing.T) {
+	as := assert.New(t)
+	tokens := TokenAuthenticator{"token": "user"}
+	a, b, err := SyncPipe(time.Second, WithClientOptions(WithCredentials(TokenCredentials("token"))),
+		WithPeerOptions(WithAuthenticator(tokens)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	as.Equal("user", b.Principal())
+
+	_, _, err = Pipe(WithClientOptions(WithCredentials(TokenCredentials("wrong"))), WithPeerOptions(WithAuthenticator(tokens)))
+	as.NotNil(err)
+}
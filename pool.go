@@ -0,0 +1,87 @@
+package fdstream
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//ErrEmptyPool is returned when pool is created without members
+var ErrEmptyPool = errors.New("Pool size should be positive")
+
+//Caller is a common interface of clients which send message and wait responce
+type Caller interface {
+	Call(ctx context.Context, m *Message) (*Message, error)
+	WriteAndReadResponce(m *Message) (*Message, error)
+}
+
+//Pool keep several connections to same address and balance calls by least in-flight requests
+// dead members are redialed in background according reconnect policy
+type Pool struct {
+	members []*ReconnectingClient
+	next    uint32
+}
+
+//NewPool create pool of size connections opened by dial
+func NewPool(dial Dialer, size int, timeout time.Duration, policy ReconnectPolicy) (*Pool, error) {
+	if size <= 0 {
+		return nil, ErrEmptyPool
+	}
+	p := &Pool{members: make([]*ReconnectingClient, size)}
+	for i := range p.members {
+		p.members[i] = NewReconnectingClient(dial, timeout, policy)
+	}
+	return p, nil
+}
+
+//pick return connected member with least in-flight calls
+// if nobody is connected it return next member by round-robin which will fail or buffer call
+func (p *Pool) pick() *ReconnectingClient {
+	var (
+		best     *ReconnectingClient
+		bestLoad int
+		start    = int(atomic.AddUint32(&p.next, 1))
+	)
+	for i := range p.members {
+		m := p.members[(start+i)%len(p.members)]
+		if m.State() != StateConnected {
+			continue
+		}
+		if load := m.InFlight(); best == nil || load < bestLoad {
+			best, bestLoad = m, load
+		}
+	}
+	if best == nil {
+		best = p.members[start%len(p.members)]
+	}
+	return best
+}
+
+//Call write message via least loaded connection and wait responce
+func (p *Pool) Call(ctx context.Context, m *Message) (*Message, error) {
+	return p.pick().Call(ctx, m)
+}
+
+//WriteAndReadResponce will write message and expect responce or error
+func (p *Pool) WriteAndReadResponce(m *Message) (*Message, error) {
+	return p.Call(context.Background(), m)
+}
+
+//Connected return count of alive members
+func (p *Pool) Connected() (n int) {
+	for _, m := range p.members {
+		if m.State() == StateConnected {
+			n++
+		}
+	}
+	return n
+}
+
+//Close shutdown all members
+func (p *Pool) Close() error {
+	for _, m := range p.members {
+		m.Close()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package fdstream
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitConnected(p *Pool, n int) bool {
+	for i := 0; i < 200; i++ {
+		if p.Connected() == n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPoolCall(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{}
+	p, err := NewPool(dialer.Dial, 3, time.Second, ReconnectPolicy{MinBackoff: time.Millisecond})
+	as.Nil(err)
+	defer p.Close()
+	as.True(waitConnected(p, 3))
+	reconnected := make(chan ConnState, 10)
+	for _, m := range p.members {
+		m.OnStateChange(func(s ConnState) {
+			if s == StateConnected {
+				reconnected <- s
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "call" + strconv.Itoa(i)
+			m, err := p.WriteAndReadResponce(&Message{Name: name})
+			as.Nil(err)
+			as.Equal(name, m.Name)
+		}(i)
+	}
+	wg.Wait()
+
+	dialer.dropAll()
+	for i := 0; i < 3; i++ {
+		<-reconnected
+	}
+	m, err := p.WriteAndReadResponce(&Message{Name: "after"})
+	as.Nil(err)
+	as.Equal("after", m.Name)
+}
+
+func TestPoolPickLeastLoaded(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{}
+	p, err := NewPool(dialer.Dial, 3, time.Second, ReconnectPolicy{MinBackoff: time.Millisecond})
+	as.Nil(err)
+	defer p.Close()
+	as.True(waitConnected(p, 3))
+
+	atomic.StoreInt32(&p.members[0].inFlight, 5)
+	atomic.StoreInt32(&p.members[1].inFlight, 1)
+	atomic.StoreInt32(&p.members[2].inFlight, 3)
+	for i := 0; i < 5; i++ {
+		as.True(p.pick() == p.members[1])
+	}
+}
+
+func TestPoolEmpty(t *testing.T) {
+	_, err := NewPool((&pipeDialer{}).Dial, 0, time.Second, ReconnectPolicy{})
+	assert.Equal(t, ErrEmptyPool, err)
+}
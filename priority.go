@@ -0,0 +1,105 @@
+package fdstream
+
+//Priority is a class of message in send queue, it is not sent to peer
+type Priority byte
+
+//Priority classes, higher class is sent first
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+const (
+	priorityClasses = 3
+	//starvationLimit is a count of messages sent while lower class wait before one of its message is sent
+	starvationLimit = 16
+)
+
+//queue return send queue for priority class, ToSendQ is a queue of normal priority
+func (c *AsyncClient) queue(p Priority) chan *Message {
+	switch p {
+	case PriorityHigh:
+		return c.sendQs[0]
+	case PriorityLow:
+		return c.sendQs[2]
+	}
+	return c.sendQs[1]
+}
+
+//Send put message to send queue of its priority and wait if the queue is full,
+// message which could not be encoded is not queued and its error is returned
+func (c *AsyncClient) Send(m *Message) error {
+	if m == nil {
+		return errNilMessage
+	}
+	if !c.IsAlive() {
+		return ErrConnectionClosed
+	}
+	if err := c.check(m); err != nil {
+		return err
+	}
+	select {
+	case c.queue(m.Priority) <- m:
+		return nil
+	case <-c.kill:
+		return ErrConnectionClosed
+	}
+}
+
+//nextMessage wait message from send queues, higher priority queue is served first
+// but waiting lower queue get one message after starvationLimit messages of higher ones,
+// without block nil message is returned when queues are empty, blocking wait return nil message when wake is closed
+func (c *AsyncClient) nextMessage(skipped *[priorityClasses]int, block bool, wake <-chan struct{}) (*Message, bool) {
+	for {
+		pick := -1
+		for i := range c.sendQs {
+			if len(c.sendQs[i]) > 0 {
+				pick = i
+				break
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		for i := priorityClasses - 1; i > pick; i-- {
+			if len(c.sendQs[i]) > 0 && skipped[i] >= starvationLimit {
+				pick = i
+				break
+			}
+		}
+		for i := range c.sendQs {
+			if i == pick || len(c.sendQs[i]) == 0 {
+				skipped[i] = 0
+			} else if i > pick {
+				skipped[i]++
+			}
+		}
+		select {
+		case m := <-c.sendQs[pick]:
+			return m, true
+		default: //taken by another writer
+		}
+	}
+
+	if !block {
+		select {
+		case <-c.kill:
+			return nil, false
+		default:
+			return nil, true
+		}
+	}
+	select {
+	case m := <-c.sendQs[0]:
+		return m, true
+	case m := <-c.sendQs[1]:
+		return m, true
+	case m := <-c.sendQs[2]:
+		return m, true
+	case <-wake:
+		return nil, true
+	case <-c.kill:
+		return nil, false
+	}
+}
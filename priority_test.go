@@ -0,0 +1,69 @@
+package fdstream
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newBlockedWriter return client which writer is blocked by first message until raw side is read
+func newBlockedWriter(t *testing.T) (*AsyncClient, net.Conn) {
+	left, right := net.Pipe()
+	c, err := NewAsyncClient(left, left)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Send(&Message{Name: "first"}))
+	assert.True(t, waitFor(func() bool { return len(c.ToSendQ) == 0 }))
+	return c, right
+}
+
+func readNames(t *testing.T, r net.Conn, n int) []string {
+	header := make([]byte, messageHeaderSize)
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		m, err := readMessage(r, header)
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestSendPriority(t *testing.T) {
+	as := assert.New(t)
+	c, raw := newBlockedWriter(t)
+	defer c.Shutdown()
+
+	as.Nil(c.Send(&Message{Name: "low", Priority: PriorityLow}))
+	as.Nil(c.Send(&Message{Name: "normal"}))
+	as.Nil(c.Send(&Message{Name: "high", Priority: PriorityHigh}))
+	c.ToSendQ <- &Message{Name: "queue"}
+
+	as.Equal([]string{"first", "high", "normal", "queue", "low"}, readNames(t, raw, 5))
+}
+
+func TestSendPriorityStarvation(t *testing.T) {
+	as := assert.New(t)
+	c, raw := newBlockedWriter(t)
+	defer c.Shutdown()
+
+	as.Nil(c.Send(&Message{Name: "low", Priority: PriorityLow}))
+	for i := 0; i < starvationLimit+4; i++ {
+		as.Nil(c.Send(&Message{Name: "high" + strconv.Itoa(i), Priority: PriorityHigh}))
+	}
+
+	names := readNames(t, raw, starvationLimit+6)
+	as.Equal("low", names[starvationLimit+1])
+	as.Equal("high"+strconv.Itoa(starvationLimit), names[starvationLimit+2])
+}
+
+func TestSendClosed(t *testing.T) {
+	as := assert.New(t)
+	c, _ := newBlockedWriter(t)
+	c.Shutdown()
+	as.Equal(ErrConnectionClosed, c.Send(&Message{Name: "late"}))
+	as.Equal(errNilMessage, c.Send(nil))
+}
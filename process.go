@@ -0,0 +1,157 @@
+package fdstream
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	//defaultCallTimeout is a timeout of sync client created without WithCallTimeout option
+	defaultCallTimeout = 10 * time.Second
+	//processKillDelay is a time given to child to exit after its stdin is closed
+	processKillDelay = 5 * time.Second
+)
+
+var (
+	//ErrProcessExited is a terminal error of process client when child exit with zero status
+	ErrProcessExited = errors.New("Process exited")
+	//ErrProcessStdio mean stdin or stdout of command is already used
+	ErrProcessStdio = errors.New("Stdin or Stdout of process already set")
+)
+
+//WithCallTimeout set timeout of sync client created by StartProcess
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		if timeout > 0 {
+			cfg.callTimeout = timeout
+		}
+	}
+}
+
+//processConn is a connection to child stdin and stdout
+type processConn struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	exited chan struct{}
+	err    error //result of Wait, it is set before exited is closed
+	closer sync.Once
+}
+
+//StartProcess start command and return sync client connected to its stdin and stdout,
+// stderr of child is written to stderr of current process if cmd.Stderr is not set.
+// Exit of child is a terminal error of client: *exec.ExitError or ErrProcessExited for zero status.
+// Shutdown close stdin of child and kill it if it does not exit in few seconds, cmd.Wait should not be called.
+func StartProcess(cmd *exec.Cmd, opts ...Option) (*SyncClient, error) {
+	if cmd.Stdin != nil || cmd.Stdout != nil {
+		return nil, ErrProcessStdio
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+	cmd.Stdin, cmd.Stdout = inR, outW
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	err = cmd.Start()
+	inR.Close() //child own its ends now
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, err
+	}
+
+	p := &processConn{cmd: cmd, stdin: inW, stdout: outR, exited: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+
+	cfg := newConfig(opts)
+	c, err := NewSyncClient(p, p, cfg.callTimeout, opts...)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+//Read read stdout of child, end of output is reported as exit of child
+func (p *processConn) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	if err == io.EOF {
+		err = p.exitErr()
+	}
+	return n, err
+}
+
+//Write write stdin of child, broken pipe is reported as exit of child
+func (p *processConn) Write(b []byte) (int, error) {
+	n, err := p.stdin.Write(b)
+	if err != nil {
+		err = p.exitErr()
+	}
+	return n, err
+}
+
+//exitErr wait child exit and return its reason
+func (p *processConn) exitErr() error {
+	<-p.exited
+	if p.err != nil {
+		return p.err
+	}
+	return ErrProcessExited
+}
+
+//Close close stdin of child and kill it if it does not exit in time
+func (p *processConn) Close() error {
+	p.closer.Do(func() {
+		p.stdin.Close()
+		go func() {
+			select {
+			case <-p.exited:
+			case <-time.After(processKillDelay):
+				p.cmd.Process.Kill()
+			}
+			p.stdout.Close()
+		}()
+	})
+	return nil
+}
+
+//stdio is a connection of child process to its parent
+type stdio struct {
+	in  *os.File
+	out *os.File
+}
+
+//Stdio return connection over stdin and stdout of current process for child started by StartProcess,
+// child should not print anything else to stdout because it break the stream, logs go to stderr
+func Stdio() io.ReadWriteCloser {
+	return &stdio{in: os.Stdin, out: os.Stdout}
+}
+
+func (s *stdio) Read(b []byte) (int, error) {
+	return s.in.Read(b)
+}
+
+func (s *stdio) Write(b []byte) (int, error) {
+	return s.out.Write(b)
+}
+
+func (s *stdio) Close() error {
+	s.in.Close()
+	return s.out.Close()
+}
@@ -0,0 +1,82 @@
+package fdstream
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestHelperProcess is a child process of process tests, it serve stdio until message "exit"
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("FDSTREAM_HELPER") != "1" {
+		return
+	}
+	s := &Server{Handler: HandlerFunc(func(m *Message) *Message {
+		if m.Name == "exit" {
+			code, _ := strconv.Atoi(string(m.Payload))
+			os.Exit(code)
+		}
+		return upperHandler(m)
+	})}
+	s.ServeConn(Stdio())
+	os.Exit(0)
+}
+
+func helperCommand() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "FDSTREAM_HELPER=1")
+	return cmd
+}
+
+func TestStartProcess(t *testing.T) {
+	as := assert.New(t)
+	cmd := helperCommand()
+	c, err := StartProcess(cmd, WithCallTimeout(5*time.Second))
+	as.Nil(err)
+
+	m, err := c.WriteAndReadResponce(&Message{Name: "hello", Payload: []byte("child")})
+	as.Nil(err)
+	as.Equal([]byte("CHILD"), m.Payload)
+
+	c.Shutdown()
+	as.Equal(ErrConnectionClosed, c.Err())
+	select {
+	case <-c.InputStream.(*processConn).exited:
+		as.True(cmd.ProcessState.Exited())
+	case <-time.After(5 * time.Second):
+		t.Error("Child should exit after shutdown")
+	}
+}
+
+func TestStartProcessExit(t *testing.T) {
+	as := assert.New(t)
+	c, err := StartProcess(helperCommand())
+	as.Nil(err)
+	defer c.Shutdown()
+
+	_, err = c.WriteAndReadResponce(&Message{Name: "exit", Payload: []byte("3")})
+	as.Equal(ErrConnectionClosed, err)
+	exit, ok := c.Err().(*exec.ExitError)
+	as.True(ok)
+	if ok {
+		as.Equal(3, exit.ExitCode())
+	}
+
+	c, err = StartProcess(helperCommand())
+	as.Nil(err)
+	defer c.Shutdown()
+	c.ToSendQ <- &Message{Name: "exit", Payload: []byte("0")}
+	<-c.Done()
+	as.Equal(ErrProcessExited, c.Err())
+}
+
+func TestStartProcessStdioSet(t *testing.T) {
+	cmd := helperCommand()
+	cmd.Stdout = os.Stderr
+	_, err := StartProcess(cmd)
+	assert.Equal(t, ErrProcessStdio, err)
+}
@@ -0,0 +1,298 @@
+package fdstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+	defaultJitter     = 0.2
+)
+
+var (
+	//ErrNotConnected is returned by ReconnectingClient when there is no connection and calls are not buffered
+	ErrNotConnected = errors.New("Client is not connected")
+	//ErrClientClosed is returned for calls to closed ReconnectingClient
+	ErrClientClosed = errors.New("Client is closed")
+	//ErrTooManyBuffered is returned when limit of calls waiting for connection is reached
+	ErrTooManyBuffered = errors.New("Too many calls wait for connection")
+)
+
+//Dialer open new transport for client, most likely TCP connection
+type Dialer func(ctx context.Context) (io.ReadWriteCloser, error)
+
+//ConnState is a state of ReconnectingClient connection
+type ConnState int32
+
+//Connection states reported by ReconnectingClient
+const (
+	StateConnecting ConnState = iota
+	StateConnected
+	StateDisconnected
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+//ReconnectPolicy describe how ReconnectingClient restore connection and what to do with calls meanwhile
+// zero value is usable and mean defaults
+type ReconnectPolicy struct {
+	//MinBackoff is a delay before first redial, default 100ms
+	MinBackoff time.Duration
+	//MaxBackoff is a limit for exponential growing delay, default 10s
+	MaxBackoff time.Duration
+	//Jitter is a random part of delay in range [0, 1], default 0.2
+	Jitter float64
+	//BufferCalls make calls wait for connection instead of failing with ErrNotConnected
+	BufferCalls bool
+	//MaxBuffered limit calls waiting for connection, default is queue size
+	MaxBuffered int
+	//Options are used for client of every connection, for example credentials
+	Options []Option
+}
+
+//backoff calculate delay before redial attempt
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	return expBackoff(p.MinBackoff, p.MaxBackoff, p.Jitter, attempt)
+}
+
+//expBackoff calculate exponential delay for attempt with random jitter part
+func expBackoff(min, max time.Duration, jitter float64, attempt int) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * jitter * float64(d))
+	}
+	return d
+}
+
+//ReconnectingClient is a SyncClient which restore connection via dialer when it breaks
+type ReconnectingClient struct {
+	dial     Dialer
+	timeout  time.Duration
+	policy   ReconnectPolicy
+	ctx      context.Context
+	cancel   context.CancelFunc
+	buffered int32
+	inFlight int32
+	state    int32
+
+	l         sync.Mutex
+	client    *SyncClient
+	connected chan struct{} //closed when client is set
+	callbacks []func(ConnState)
+}
+
+//NewReconnectingClient create client and start dial in background
+// timeout is a message timeout for underlying SyncClient
+func NewReconnectingClient(dial Dialer, timeout time.Duration, policy ReconnectPolicy) *ReconnectingClient {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = defaultMinBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxBackoff
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = policy.MinBackoff
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		policy.Jitter = defaultJitter
+	}
+	if policy.MaxBuffered <= 0 {
+		policy.MaxBuffered = defaultQSize
+	}
+	c := &ReconnectingClient{
+		dial:      dial,
+		timeout:   timeout,
+		policy:    policy,
+		connected: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	go c.connectionWorker()
+	return c
+}
+
+//OnStateChange register callback for connection state changes
+// callbacks are called one by one from connection goroutine so should not block
+func (c *ReconnectingClient) OnStateChange(f func(ConnState)) {
+	c.l.Lock()
+	c.callbacks = append(c.callbacks, f)
+	c.l.Unlock()
+}
+
+//State return current connection state
+func (c *ReconnectingClient) State() ConnState {
+	return ConnState(atomic.LoadInt32(&c.state))
+}
+
+func (c *ReconnectingClient) setState(s ConnState) {
+	if ConnState(atomic.SwapInt32(&c.state, int32(s))) == s {
+		return
+	}
+	c.l.Lock()
+	callbacks := c.callbacks
+	c.l.Unlock()
+	for _, f := range callbacks {
+		f(s)
+	}
+}
+
+//connectionWorker dial, wait connection break and dial again until client closed
+func (c *ReconnectingClient) connectionWorker() {
+	var attempt int
+	for c.ctx.Err() == nil {
+		c.setState(StateConnecting)
+		rw, err := c.dial(c.ctx)
+		if err == nil {
+			var cl *SyncClient
+			if cl, err = NewSyncClient(rw, rw, c.timeout, c.policy.Options...); err != nil {
+				rw.Close()
+			} else {
+				attempt = 0
+				c.serve(cl)
+				continue
+			}
+		}
+
+		select {
+		case <-time.After(c.policy.backoff(attempt)):
+			attempt++
+		case <-c.ctx.Done():
+		}
+	}
+	c.setState(StateClosed)
+}
+
+//serve publish client and wait until it is dead
+func (c *ReconnectingClient) serve(cl *SyncClient) {
+	c.l.Lock()
+	c.client = cl
+	close(c.connected)
+	c.l.Unlock()
+	c.setState(StateConnected)
+
+	select {
+	case <-cl.Done():
+	case <-c.ctx.Done():
+		cl.Shutdown()
+	}
+
+	c.l.Lock()
+	c.client = nil
+	c.connected = make(chan struct{})
+	c.l.Unlock()
+	c.setState(StateDisconnected)
+}
+
+//getClient return live client or wait for it according policy
+func (c *ReconnectingClient) getClient(ctx context.Context) (*SyncClient, error) {
+	c.l.Lock()
+	cl, connected := c.client, c.connected
+	c.l.Unlock()
+	if cl != nil {
+		return cl, nil
+	}
+	if c.ctx.Err() != nil {
+		return nil, ErrClientClosed
+	}
+	if !c.policy.BufferCalls {
+		return nil, ErrNotConnected
+	}
+
+	if atomic.AddInt32(&c.buffered, 1) > int32(c.policy.MaxBuffered) {
+		atomic.AddInt32(&c.buffered, -1)
+		return nil, ErrTooManyBuffered
+	}
+	defer atomic.AddInt32(&c.buffered, -1)
+	for {
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, ErrClientClosed
+		}
+		c.l.Lock()
+		cl, connected = c.client, c.connected
+		c.l.Unlock()
+		if cl != nil {
+			return cl, nil
+		}
+	}
+}
+
+//Call write message via current connection and wait responce
+// calls in progress during connection break fail with ErrConnectionClosed
+func (c *ReconnectingClient) Call(ctx context.Context, m *Message) (*Message, error) {
+	atomic.AddInt32(&c.inFlight, 1)
+	defer atomic.AddInt32(&c.inFlight, -1)
+	cl, err := c.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Call(ctx, m)
+}
+
+//CallWithRetry call and repeat message while error is retryable according policy
+// on same connection message is repeated with same ID, after reconnect only idempotent message is repeated
+func (c *ReconnectingClient) CallWithRetry(ctx context.Context, m *Message, policy RetryPolicy) (*Message, error) {
+	atomic.AddInt32(&c.inFlight, 1)
+	defer atomic.AddInt32(&c.inFlight, -1)
+	var (
+		last *SyncClient
+		req  *Message
+	)
+	return policy.do(ctx, m, func(int) (*Message, error) {
+		cl, err := c.getClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cl == last {
+			return cl.invoke(ctx, req)
+		}
+		last, req = cl, new(Message)
+		*req = *m //previous connection could still hold message in send queue
+		return cl.Call(ctx, req)
+	})
+}
+
+//WriteAndReadResponce will write message and expect responce or error
+func (c *ReconnectingClient) WriteAndReadResponce(m *Message) (*Message, error) {
+	return c.Call(context.Background(), m)
+}
+
+//InFlight return count of calls which wait for responce or connection
+func (c *ReconnectingClient) InFlight() int {
+	return int(atomic.LoadInt32(&c.inFlight))
+}
+
+//Close stop reconnection and shutdown current connection
+func (c *ReconnectingClient) Close() error {
+	c.cancel()
+	return nil
+}
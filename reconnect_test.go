@@ -0,0 +1,155 @@
+package fdstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//pipeDialer dial in memory connections served by echoPeer
+type pipeDialer struct {
+	l     sync.Mutex
+	conns []net.Conn
+	fail  bool
+}
+
+func (d *pipeDialer) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.fail {
+		return nil, errors.New("dial failed")
+	}
+	client, server := net.Pipe()
+	d.conns = append(d.conns, server)
+	cl, _ := NewAsyncClient(server, server)
+	go echoPeer(cl)
+	return client, nil
+}
+
+func (d *pipeDialer) setFail(fail bool) {
+	d.l.Lock()
+	d.fail = fail
+	d.l.Unlock()
+}
+
+func (d *pipeDialer) dropAll() {
+	d.l.Lock()
+	for _, c := range d.conns {
+		c.Close()
+	}
+	d.conns = nil
+	d.l.Unlock()
+}
+
+func waitState(c *ReconnectingClient, s ConnState) bool {
+	for i := 0; i < 200; i++ {
+		if c.State() == s {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReconnectingClientReconnect(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{fail: true}
+	c := NewReconnectingClient(dialer.Dial, time.Second, ReconnectPolicy{MinBackoff: time.Millisecond})
+	defer c.Close()
+
+	states := make(chan ConnState, 10)
+	c.OnStateChange(func(s ConnState) { states <- s })
+	dialer.setFail(false)
+	as.Equal(StateConnected, <-states)
+
+	m, err := c.WriteAndReadResponce(&Message{Name: "first"})
+	as.Nil(err)
+	as.Equal("first", m.Name)
+
+	dialer.dropAll()
+	as.Equal(StateDisconnected, <-states)
+	as.Equal(StateConnecting, <-states)
+	as.Equal(StateConnected, <-states)
+
+	m, err = c.WriteAndReadResponce(&Message{Name: "second"})
+	as.Nil(err)
+	as.Equal("second", m.Name)
+
+	c.Close()
+	as.True(waitState(c, StateClosed))
+	_, err = c.WriteAndReadResponce(&Message{Name: "closed"})
+	as.Equal(ErrClientClosed, err)
+}
+
+func TestReconnectingClientFailCalls(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{fail: true}
+	c := NewReconnectingClient(dialer.Dial, time.Second, ReconnectPolicy{MinBackoff: time.Millisecond})
+	defer c.Close()
+
+	_, err := c.WriteAndReadResponce(&Message{Name: "fail"})
+	as.Equal(ErrNotConnected, err)
+}
+
+func TestReconnectingClientBufferCalls(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{fail: true}
+	c := NewReconnectingClient(dialer.Dial, time.Second, ReconnectPolicy{
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+		BufferCalls: true,
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err := c.Call(ctx, &Message{Name: "timeout"})
+	cancel()
+	as.Equal(context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		dialer.setFail(false)
+	}()
+	m, err := c.WriteAndReadResponce(&Message{Name: "buffered"})
+	as.Nil(err)
+	as.Equal("buffered", m.Name)
+}
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	as := assert.New(t)
+	p := ReconnectPolicy{MinBackoff: time.Millisecond, MaxBackoff: 8 * time.Millisecond}
+	as.Equal(time.Millisecond, p.backoff(0))
+	as.Equal(4*time.Millisecond, p.backoff(2))
+	as.Equal(8*time.Millisecond, p.backoff(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(3)
+		as.True(d >= 4*time.Millisecond && d <= 12*time.Millisecond)
+	}
+}
+
+func TestReconnectingClientCallWithRetry(t *testing.T) {
+	as := assert.New(t)
+	dialer := &pipeDialer{fail: true}
+	c := NewReconnectingClient(dialer.Dial, time.Second, ReconnectPolicy{MinBackoff: time.Millisecond})
+	defer c.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		dialer.setFail(false)
+	}()
+	m, err := c.CallWithRetry(context.Background(), &Message{Name: "retry"}, RetryPolicy{
+		MaxAttempts: 10,
+		MinBackoff:  5 * time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+	})
+	as.Nil(err)
+	as.Equal("retry", m.Name)
+}
@@ -0,0 +1,86 @@
+package fdstream
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryMinBackoff = 50 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+)
+
+//RetryPolicy describe how call is repeated on transient failures
+// zero value is usable and mean defaults
+type RetryPolicy struct {
+	//MaxAttempts is a total count of attempts including first one, default 3
+	MaxAttempts int
+	//MinBackoff is a delay before first retry, default 50ms
+	MinBackoff time.Duration
+	//MaxBackoff is a limit for exponential growing delay, default 1s
+	MaxBackoff time.Duration
+	//Jitter is a random part of delay in range [0, 1]
+	Jitter float64
+	//Codes is a list of error codes to retry, default CodeTimeout and CodeConnectionClosed
+	Codes []byte
+	//Deduplicated should be set only when server has DedupWindow longer than all attempts,
+	// without it not idempotent message is not repeated after timeout because it could be executed twice
+	Deduplicated bool
+}
+
+func (p *RetryPolicy) normalize() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = defaultRetryMinBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = p.MinBackoff
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = 0
+	}
+	if p.Codes == nil {
+		p.Codes = []byte{CodeTimeout, CodeConnectionClosed}
+	}
+}
+
+//retryable check that message could be sent again after error
+// message lost with connection is repeated only if it is idempotent
+// because new connection can not de-duplicate it, timed out one also need de-duplicating server
+func (p *RetryPolicy) retryable(err error, m *Message) bool {
+	if err == ErrNotConnected { //message was not sent at all
+		return true
+	}
+	code, ok := ErrorCode(err)
+	if !ok || (code == CodeConnectionClosed && !m.Idempotent) || (code == CodeTimeout && !m.Idempotent && !p.Deduplicated) {
+		return false
+	}
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+//do run attempts of call until success, not retryable error or attempts limit
+func (p RetryPolicy) do(ctx context.Context, m *Message, call func(attempt int) (*Message, error)) (*Message, error) {
+	p.normalize()
+	for attempt := 0; ; attempt++ {
+		resp, err := call(attempt)
+		if err == nil || attempt+1 >= p.MaxAttempts || !p.retryable(err, m) {
+			return resp, err
+		}
+		select {
+		case <-time.After(expBackoff(p.MinBackoff, p.MaxBackoff, p.Jitter, attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
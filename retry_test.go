@@ -0,0 +1,99 @@
+package fdstream
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	as := assert.New(t)
+	p := RetryPolicy{}
+	p.normalize()
+
+	plain, idempotent := &Message{}, &Message{Idempotent: true}
+	as.True(p.retryable(ErrNotConnected, plain))
+	as.False(p.retryable(ErrTimeout, plain))
+	as.True(p.retryable(ErrTimeout, idempotent))
+	as.False(p.retryable(ErrConnectionClosed, plain))
+	as.True(p.retryable(ErrConnectionClosed, idempotent))
+	as.False(p.retryable(&ResponseError{Code: CodeGeneralError}, idempotent))
+	as.False(p.retryable(context.Canceled, idempotent))
+
+	p.Deduplicated = true
+	as.True(p.retryable(ErrTimeout, plain))
+	as.False(p.retryable(ErrConnectionClosed, plain))
+
+	p.Codes = []byte{CodeGeneralError}
+	as.True(p.retryable(&ResponseError{Code: CodeGeneralError}, plain))
+	as.False(p.retryable(ErrTimeout, plain))
+}
+
+//slowServer answer after delay and count handler executions
+func slowServer(t *testing.T, delay time.Duration, executed *int32) *SyncClient {
+	s := &Server{
+		DedupWindow: time.Second,
+		Handler: HandlerFunc(func(m *Message) *Message {
+			if atomic.AddInt32(executed, 1) == 1 {
+				time.Sleep(delay)
+			}
+			return &Message{Name: m.Name}
+		}),
+	}
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	cl, err := NewSyncClient(client, client, 30*time.Millisecond)
+	assert.Nil(t, err)
+	return cl
+}
+
+func TestCallWithRetryDeduplicated(t *testing.T) {
+	as := assert.New(t)
+	var executed int32
+	cl := slowServer(t, 60*time.Millisecond, &executed)
+	defer cl.Shutdown()
+
+	m, err := cl.CallWithRetry(context.Background(), &Message{Name: "once"}, RetryPolicy{MinBackoff: time.Millisecond, Deduplicated: true})
+	as.Nil(err)
+	as.Equal("once", m.Name)
+	as.Equal(int32(1), atomic.LoadInt32(&executed))
+}
+
+func TestCallWithRetryNotDeduplicated(t *testing.T) {
+	as := assert.New(t)
+	var executed int32
+	cl := slowServer(t, 60*time.Millisecond, &executed)
+	defer cl.Shutdown()
+
+	//without de-duplication timed out message is not repeated
+	_, err := cl.CallWithRetry(context.Background(), &Message{Name: "once"}, RetryPolicy{MinBackoff: time.Millisecond})
+	as.Equal(ErrTimeout, err)
+	time.Sleep(60 * time.Millisecond)
+	as.Equal(int32(1), atomic.LoadInt32(&executed))
+}
+
+func TestCallWithRetryIdempotent(t *testing.T) {
+	as := assert.New(t)
+	var executed int32
+	cl := slowServer(t, 60*time.Millisecond, &executed)
+	defer cl.Shutdown()
+
+	m, err := cl.CallWithRetry(context.Background(), &Message{Name: "twice", Idempotent: true}, RetryPolicy{MinBackoff: time.Millisecond})
+	as.Nil(err)
+	as.Equal("twice", m.Name)
+	as.Equal(int32(2), atomic.LoadInt32(&executed))
+}
+
+func TestCallWithRetryAttempts(t *testing.T) {
+	as := assert.New(t)
+	var executed int32
+	cl := slowServer(t, time.Second, &executed)
+	defer cl.Shutdown()
+
+	_, err := cl.CallWithRetry(context.Background(), &Message{Name: "fail"}, RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, Deduplicated: true})
+	as.Equal(ErrTimeout, err)
+}
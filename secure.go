@@ -0,0 +1,210 @@
+package fdstream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	//saltSize is a size of random salt which start every direction of encrypted connection
+	saltSize = 16
+	//maxRecordSize is a limit of plaintext in single encrypted record
+	maxRecordSize = 1 << 20
+	//minKeySize is a minimal length of pre-shared key
+	minKeySize = 16
+)
+
+var (
+	//ErrShortKey mean pre-shared key is too short
+	ErrShortKey = errors.New("Pre-shared key should be at least 16 bytes")
+	//ErrDecrypt mean record was not encrypted by same key or was changed
+	ErrDecrypt = errors.New("Record authentication failed")
+	//ErrRecordTooLong mean peer send record bigger than allowed
+	ErrRecordTooLong = errors.New("Too long encrypted record")
+	//ErrReflected mean peer repeat own records of connection back
+	ErrReflected = errors.New("Reflected encrypted stream")
+)
+
+//WithPSK encrypt and authenticate every frame by AES-GCM with key derived from pre-shared key,
+// each side send random salt and key of direction is derived from salts of both sides, so records
+// of another connection could not be replayed, both sides should use same key
+func WithPSK(key []byte) Option {
+	return func(cfg *config) {
+		cfg.psk = key
+	}
+}
+
+//secureConn encrypt every write as a record [length, sealed data] and decrypt records on read
+type secureConn struct {
+	w   io.Writer
+	r   io.ReadCloser
+	psk []byte
+
+	salt []byte //own random salt
+
+	wl       sync.Mutex
+	sealer   cipher.AEAD
+	saltErr  error //error of sending own salt
+	wCounter uint64
+
+	sl       sync.Mutex
+	peerSalt []byte
+
+	opener   cipher.AEAD
+	rCounter uint64
+	header   [4]byte
+	record   []byte
+	plain    []byte
+}
+
+func newSecureConn(w io.Writer, r io.ReadCloser, psk []byte) (*secureConn, error) {
+	if len(psk) < minKeySize {
+		return nil, ErrShortKey
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	c := &secureConn{w: w, r: r, psk: psk, salt: salt}
+	c.wl.Lock() //writes wait own salt
+	go c.sendSalt(salt)
+	return c, nil
+}
+
+//newAEAD derive key of direction from pre-shared key and salts of sender and receiver by HKDF-SHA256
+func newAEAD(psk, senderSalt, receiverSalt []byte) (cipher.AEAD, error) {
+	extract := hmac.New(sha256.New, append(append([]byte{}, senderSalt...), receiverSalt...))
+	extract.Write(psk)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("fdstream aes-gcm\x01"))
+	block, err := aes.NewCipher(expand.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(counter uint64, size int) []byte {
+	n := make([]byte, size)
+	binary.BigEndian.PutUint64(n[size-8:], counter)
+	return n
+}
+
+//sendSalt write own salt without waiting peer, so both sides can start from read or write
+// write lock is taken by newSecureConn and released when salt is written
+func (c *secureConn) sendSalt(salt []byte) {
+	defer c.wl.Unlock()
+	_, c.saltErr = c.w.Write(salt)
+}
+
+//readSalt read salt of peer once, it is read by reader or by writer which wait key of its direction
+func (c *secureConn) readSalt() ([]byte, error) {
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	if c.peerSalt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(c.r, salt); err != nil {
+			return nil, err
+		}
+		if hmac.Equal(salt, c.salt) {
+			return nil, ErrReflected
+		}
+		c.peerSalt = salt
+	}
+	return c.peerSalt, nil
+}
+
+//Write encrypt b as one or few records, salt of peer is waited before first record
+func (c *secureConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if c.saltErr != nil {
+		return 0, c.saltErr
+	}
+	if c.sealer == nil {
+		peerSalt, err := c.readSalt()
+		if err != nil {
+			return 0, err
+		}
+		if c.sealer, err = newAEAD(c.psk, c.salt, peerSalt); err != nil {
+			return 0, err
+		}
+	}
+	out := make([]byte, 0, len(b)+(len(b)/maxRecordSize+1)*(4+c.sealer.Overhead()))
+	for rest := b; len(rest) > 0; {
+		n := len(rest)
+		if n > maxRecordSize {
+			n = maxRecordSize
+		}
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(n+c.sealer.Overhead()))
+		out = append(out, length[:]...)
+		out = c.sealer.Seal(out, nonce(c.wCounter, c.sealer.NonceSize()), rest[:n], length[:])
+		c.wCounter++
+		rest = rest[n:]
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+//Read decrypt next record when previous one is read
+func (c *secureConn) Read(b []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *secureConn) readRecord() error {
+	if c.opener == nil {
+		peerSalt, err := c.readSalt()
+		if err != nil {
+			return err
+		}
+		if c.opener, err = newAEAD(c.psk, peerSalt, c.salt); err != nil {
+			return err
+		}
+	}
+	if _, err := io.ReadFull(c.r, c.header[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(c.header[:]))
+	if size > maxRecordSize+c.opener.Overhead() || size < c.opener.Overhead() {
+		return ErrRecordTooLong
+	}
+	if cap(c.record) < size {
+		c.record = make([]byte, size)
+	}
+	record := c.record[:size]
+	if _, err := io.ReadFull(c.r, record); err != nil {
+		return err
+	}
+	plain, err := c.opener.Open(record[:0], nonce(c.rCounter, c.opener.NonceSize()), record, c.header[:])
+	if err != nil {
+		return ErrDecrypt
+	}
+	c.rCounter++
+	c.plain = plain
+	return nil
+}
+
+//Close close underlying reader
+func (c *secureConn) Close() error {
+	return c.r.Close()
+}
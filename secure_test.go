@@ -0,0 +1,151 @@
+package fdstream
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPSK = []byte("0123456789abcdef0123456789abcdef")
+
+func TestPSKRoundTrip(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	s := &Server{Handler: HandlerFunc(upperHandler), Options: []Option{WithPSK(testPSK)}}
+	go s.ServeConn(right)
+	defer s.Close()
+
+	c, err := NewSyncClient(left, left, time.Second, WithPSK(testPSK))
+	as.Nil(err)
+	defer c.Shutdown()
+	for i := 0; i < 3; i++ {
+		m, err := c.WriteAndReadResponce(&Message{Name: "secret", Payload: []byte("payload")})
+		as.Nil(err)
+		as.Equal([]byte("PAYLOAD"), m.Payload)
+	}
+}
+
+//newSecurePair create writer to wire and reader of wire which salts are known to each other
+func newSecurePair(t *testing.T, wire *bytes.Buffer) (w, r *secureConn) {
+	r, err := newSecureConn(ioutil.Discard, ioutil.NopCloser(wire), testPSK)
+	assert.Nil(t, err)
+	w, err = newSecureConn(wire, ioutil.NopCloser(bytes.NewReader(r.salt)), testPSK)
+	assert.Nil(t, err)
+	return w, r
+}
+
+//withSalt create reader of data with salt of another connection
+func withSalt(salt, data []byte) *secureConn {
+	c, _ := newSecureConn(ioutil.Discard, ioutil.NopCloser(bytes.NewReader(data)), testPSK)
+	c.salt = salt
+	return c
+}
+
+func TestPSKConfidential(t *testing.T) {
+	as := assert.New(t)
+	var wire bytes.Buffer
+	w, r := newSecurePair(t, &wire)
+	w.Write([]byte("top secret"))
+	w.Write([]byte("top secret"))
+	as.False(bytes.Contains(wire.Bytes(), []byte("secret")))
+
+	sent := append([]byte{}, wire.Bytes()...)
+	got := make([]byte, 20)
+	_, err := io.ReadFull(r, got)
+	as.Nil(err)
+	as.Equal([]byte("top secrettop secret"), got)
+
+	//tampered, reordered and reflected records are rejected
+	tampered := append([]byte{}, sent...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = io.ReadFull(withSalt(r.salt, tampered), got)
+	as.Equal(ErrDecrypt, err)
+
+	record := (len(sent) - saltSize) / 2
+	swapped := append(append(append([]byte{}, sent[:saltSize]...), sent[saltSize+record:]...), sent[saltSize:saltSize+record]...)
+	_, err = withSalt(r.salt, swapped).Read(got)
+	as.Equal(ErrDecrypt, err)
+
+	_, err = withSalt(w.salt, sent).Read(got)
+	as.Equal(ErrReflected, err)
+}
+
+func TestPSKReplay(t *testing.T) {
+	as := assert.New(t)
+	var wire bytes.Buffer
+	w, _ := newSecurePair(t, &wire)
+	w.Write([]byte("transfer money"))
+
+	//records recorded from one connection are not accepted by peer with another salt
+	r, err := newSecureConn(ioutil.Discard, ioutil.NopCloser(bytes.NewReader(wire.Bytes())), testPSK)
+	as.Nil(err)
+	_, err = r.Read(make([]byte, 20))
+	as.Equal(ErrDecrypt, err)
+}
+
+func TestPSKWrongKey(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	a, err := NewAsyncClient(left, left, WithPSK(testPSK))
+	as.Nil(err)
+	defer a.Shutdown()
+	b, err := NewAsyncClient(right, right, WithPSK([]byte("another key of 16+ bytes")))
+	as.Nil(err)
+
+	a.ToSendQ <- &Message{Name: "hello"}
+	<-b.Done()
+	as.Equal(ErrDecrypt, b.Err())
+
+	_, err = NewAsyncClient(left, left, WithPSK([]byte("short")))
+	as.Equal(ErrShortKey, err)
+}
+
+func TestPSKServerWritesFirst(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	client, err := newSecureConn(left, left, testPSK)
+	as.Nil(err)
+	server, err := newSecureConn(right, right, testPSK)
+	as.Nil(err)
+	defer client.Close()
+
+	go server.Write([]byte("greeting"))
+	got := make([]byte, 8)
+	_, err = io.ReadFull(client, got)
+	as.Nil(err)
+	as.Equal([]byte("greeting"), got)
+}
+
+func TestPSKBothRead(t *testing.T) {
+	as := assert.New(t)
+	left, right := net.Pipe()
+	a, err := newSecureConn(left, left, testPSK)
+	as.Nil(err)
+	b, err := newSecureConn(right, right, testPSK)
+	as.Nil(err)
+	defer a.Close()
+
+	//both sides wait data of peer first, salts are sent anyway
+	fromA, fromB := make(chan string, 1), make(chan string, 1)
+	read := func(c *secureConn, to chan string) {
+		got := make([]byte, 2)
+		if _, err := io.ReadFull(c, got); err == nil {
+			to <- string(got)
+		}
+	}
+	go read(a, fromB)
+	go read(b, fromA)
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = a.Write([]byte("hi"))
+	as.Nil(err)
+	_, err = b.Write([]byte("yo"))
+	as.Nil(err)
+	as.Equal("hi", <-fromA)
+	as.Equal("yo", <-fromB)
+}
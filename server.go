@@ -0,0 +1,341 @@
+package fdstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultServerWorkers = 4
+
+//ErrServerClosed is returned by Serve after server Close
+var ErrServerClosed = errors.New("Server closed")
+
+//Handler respond to income message, nil responce mean no answer
+type Handler interface {
+	ServeMessage(m *Message) *Message
+}
+
+//HandlerFunc is an adapter to use ordinary function as Handler
+type HandlerFunc func(m *Message) *Message
+
+//ServeMessage call f(m)
+func (f HandlerFunc) ServeMessage(m *Message) *Message {
+	return f(m)
+}
+
+//Server accept connections and answer every income message by Handler
+// responce get ID of request so it can be read by SyncClient
+type Server struct {
+	Handler Handler
+	//Workers is a count of goroutines handling messages of single connection, default 4
+	Workers int
+	//DedupWindow is a time during which repeated request ID is not executed again on same connection
+	// idempotent messages and messages with zero ID are not de-duplicated, zero disable de-duplication
+	DedupWindow time.Duration
+	//Options are used for client of every connection
+	Options []Option
+	//Authenticator verify credentials of every connection before its messages are handled,
+	// principal is available to handler by PrincipalFromContext
+	Authenticator Authenticator
+	//Tracer observe every handled request, traceparent of request is available by TraceparentFromContext
+	Tracer Tracer
+	//Interceptors wrap every handler invocation, first one is outermost
+	Interceptors []ServerInterceptor
+	//Logger report events of every connection like WithLogger, nil logger is silent
+	Logger *slog.Logger
+
+	l         sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*AsyncClient]*serverConn
+	closed    bool
+	finished  Stats  //counters of closed connections
+	lastID    uint64 //ID of last accepted connection
+}
+
+//ListenAndServe listen TCP address and serve connections by handler
+func ListenAndServe(addr string, handler Handler) error {
+	s := &Server{Handler: handler}
+	return s.ListenAndServe(addr)
+}
+
+//ListenAndServe listen TCP address and serve connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+//Serve accept connections from listener until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.l.Lock()
+	if s.closed {
+		s.l.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.l.Unlock()
+
+	defer func() {
+		s.l.Lock()
+		delete(s.listeners, l)
+		s.l.Unlock()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.l.Lock()
+			closed := s.closed
+			s.l.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+//ServeConn serve single connection and block until it is closed
+// context of messages carry Peer and is done when connection is closed
+func (s *Server) ServeConn(rw io.ReadWriteCloser) error {
+	peer, err := newPeer(rw)
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	opts := append(s.Options[:len(s.Options):len(s.Options)], serveChannels())
+	if s.Authenticator != nil {
+		opts = append(opts[:len(opts):len(opts)], WithAuthenticator(s.Authenticator))
+	}
+	if s.Logger != nil {
+		opts = append(opts[:len(opts):len(opts)], WithLogger(s.Logger))
+	}
+	cl, err := NewAsyncClient(rw, rw, opts...)
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	ctx := context.WithValue(context.Background(), peerKey{}, peer)
+	if cl.principal != "" {
+		ctx = context.WithValue(ctx, principalKey{}, cl.principal)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn := s.track(cl, peer)
+	if conn == nil {
+		cl.Shutdown()
+		return ErrServerClosed
+	}
+	defer s.untrack(cl)
+
+	var dedup *dedupCache
+	if s.DedupWindow > 0 {
+		dedup = newDedupCache(s.DedupWindow)
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = defaultServerWorkers
+	}
+	handler := chainHandler(s.Handler, s.Interceptors)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case m := <-cl.ToReadQ:
+					m.ctx = extractTraceparent(ctx, m)
+					atomic.AddInt64(&conn.inFlight, 1)
+					s.handle(cl, handler, dedup, m)
+					atomic.AddInt64(&conn.inFlight, -1)
+					if m.Channel != 0 {
+						cl.Channel(m.Channel).release()
+					}
+				case <-cl.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Server) track(cl *AsyncClient, peer *Peer) *serverConn {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return nil
+	}
+	if s.conns == nil {
+		s.conns = make(map[*AsyncClient]*serverConn)
+	}
+	s.lastID++
+	conn := &serverConn{id: s.lastID, client: cl, peer: peer, started: time.Now()}
+	s.conns[cl] = conn
+	return conn
+}
+
+func (s *Server) untrack(cl *AsyncClient) {
+	st := cl.Stats()
+	st.SendQueue, st.ReadQueue = 0, 0 //queues of closed connection are dropped
+	s.l.Lock()
+	delete(s.conns, cl)
+	s.finished.add(&st)
+	s.l.Unlock()
+}
+
+//Stats return sum of counters of all connections served by server and queue depths of open ones
+func (s *Server) Stats() Stats {
+	s.l.Lock()
+	defer s.l.Unlock()
+	st := s.finished
+	for cl := range s.conns {
+		c := cl.Stats()
+		st.add(&c)
+	}
+	return st
+}
+
+//handle run handler for message and send responce back
+func (s *Server) handle(cl *AsyncClient, handler Handler, dedup *dedupCache, m *Message) {
+	var resp *Message
+	if dedup != nil && !m.Idempotent && m.ID != 0 {
+		var seen bool
+		key := dedupKey{channel: m.Channel, id: m.ID}
+		if resp, seen = dedup.begin(key); seen {
+			send(cl, resp) //repeat finished responce, in-progress one will be sent by first execution
+			return
+		}
+		defer func() {
+			dedup.finish(key, resp)
+		}()
+	}
+
+	if resp = s.serve(handler, m); resp != nil {
+		resp.ID, resp.Channel = m.ID, m.Channel
+		send(cl, resp)
+	}
+}
+
+//serve run handler and tracer around it
+func (s *Server) serve(handler Handler, m *Message) *Message {
+	if s.Tracer == nil {
+		return handler.ServeMessage(m)
+	}
+	start := time.Now()
+	m.ctx = s.Tracer.Start(m.Context(), m)
+	resp := handler.ServeMessage(m)
+	var err error
+	if resp != nil && resp.Code >= 200 {
+		err = &ResponseError{Code: resp.Code, Text: resp.Name}
+	}
+	s.Tracer.End(m.ctx, m, time.Since(start), err)
+	return resp
+}
+
+//send put responce to send queue, responce of channel wait for window of the channel
+func send(cl *AsyncClient, m *Message) {
+	if m == nil {
+		return
+	}
+	if m.Channel != 0 {
+		cl.Channel(m.Channel).Write(m)
+		return
+	}
+	select {
+	case cl.queue(m.Priority) <- m:
+	case <-cl.Done():
+	}
+}
+
+//Close stop all listeners and connections
+func (s *Server) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for cl := range s.conns {
+		cl.Shutdown()
+	}
+	return nil
+}
+
+type dedupEntry struct {
+	resp    *Message
+	done    bool
+	expires int64
+}
+
+//dedupKey is a request ID in its channel, clients of every channel number requests from 1
+type dedupKey struct {
+	channel uint16
+	id      uint32
+}
+
+//dedupCache remember request IDs of connection for a window after they are handled
+type dedupCache struct {
+	window  time.Duration
+	l       sync.Mutex
+	entries map[dedupKey]*dedupEntry
+	order   []dedupKey //finished entries in order of expiration
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: make(map[dedupKey]*dedupEntry, defaultQSize),
+	}
+}
+
+//begin register request ID, if it was seen it return responce of finished request
+func (d *dedupCache) begin(id dedupKey) (*Message, bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.expire(time.Now().UnixNano())
+	if e, ok := d.entries[id]; ok {
+		return e.resp, true
+	}
+	d.entries[id] = &dedupEntry{}
+	return nil, false
+}
+
+//finish save responce of request for window
+func (d *dedupCache) finish(id dedupKey, resp *Message) {
+	d.l.Lock()
+	if e, ok := d.entries[id]; ok {
+		e.resp, e.done = resp, true
+		e.expires = time.Now().Add(d.window).UnixNano()
+		d.order = append(d.order, id)
+	}
+	d.l.Unlock()
+}
+
+//expire remove old finished entries, in-progress entries are not in order so they never stop cleanup
+func (d *dedupCache) expire(now int64) {
+	var i int
+	for ; i < len(d.order); i++ {
+		if d.entries[d.order[i]].expires > now {
+			break
+		}
+		delete(d.entries, d.order[i])
+	}
+	d.order = d.order[i:]
+}
@@ -0,0 +1,145 @@
+package fdstream
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func upperHandler(m *Message) *Message {
+	return &Message{Name: m.Name, Payload: []byte(strings.ToUpper(string(m.Payload)))}
+}
+
+func TestServerServeConn(t *testing.T) {
+	as := assert.New(t)
+	s := &Server{Handler: HandlerFunc(upperHandler)}
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(server) }()
+
+	cl, err := NewSyncClient(client, client, time.Second)
+	as.Nil(err)
+	m, err := cl.WriteAndReadResponce(&Message{Name: "upper", Payload: []byte("value")})
+	as.Nil(err)
+	as.Equal("upper", m.Name)
+	as.Equal([]byte("VALUE"), m.Payload)
+
+	cl.Shutdown()
+	as.Nil(<-done)
+}
+
+func TestServerServe(t *testing.T) {
+	as := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.Nil(err)
+	s := &Server{Handler: HandlerFunc(upperHandler)}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	as.Nil(err)
+	cl, err := NewSyncClient(conn, conn, time.Second)
+	as.Nil(err)
+	m, err := cl.WriteAndReadResponce(&Message{Name: "tcp", Payload: []byte("tcp")})
+	as.Nil(err)
+	as.Equal([]byte("TCP"), m.Payload)
+
+	s.Close()
+	as.Equal(ErrServerClosed, <-done)
+	select {
+	case <-cl.Done():
+	case <-time.After(time.Second):
+		t.Error("Connection should be closed by server")
+	}
+}
+
+func TestDedupCache(t *testing.T) {
+	as := assert.New(t)
+	d := newDedupCache(10 * time.Millisecond)
+
+	_, seen := d.begin(dedupKey{id: 1})
+	as.False(seen)
+	resp, seen := d.begin(dedupKey{id: 1})
+	as.True(seen)
+	as.Nil(resp)
+
+	answer := &Message{ID: 1}
+	d.finish(dedupKey{id: 1}, answer)
+	resp, seen = d.begin(dedupKey{id: 1})
+	as.True(seen)
+	as.Equal(answer, resp)
+
+	time.Sleep(20 * time.Millisecond)
+	_, seen = d.begin(dedupKey{id: 1})
+	as.False(seen)
+	as.Len(d.entries, 1)
+	as.Empty(d.order) //in-progress entry wait for finish to be ordered
+}
+
+func TestDedupCacheExpireBehindInProgress(t *testing.T) {
+	as := assert.New(t)
+	d := newDedupCache(time.Millisecond)
+	_, seen := d.begin(dedupKey{id: 1}) //long running request
+	as.False(seen)
+	for id := uint32(2); id < 10; id++ {
+		d.begin(dedupKey{id: id})
+		d.finish(dedupKey{id: id}, &Message{ID: id})
+	}
+	time.Sleep(2 * time.Millisecond)
+	d.begin(dedupKey{id: 10})
+	as.Len(d.entries, 2) //in-progress 1 and new 10
+	as.Empty(d.order)
+
+	d.finish(dedupKey{id: 1}, nil)
+	_, seen = d.begin(dedupKey{id: 1})
+	as.True(seen)
+}
+
+func TestServerChannels(t *testing.T) {
+	as := assert.New(t)
+	s := &Server{Handler: HandlerFunc(upperHandler)}
+	defer s.Close()
+	a, b := net.Pipe()
+	go s.ServeConn(b)
+	cl, err := NewSyncClient(a, a, time.Second)
+	as.Nil(err)
+	defer cl.Shutdown()
+
+	ch := cl.Channel(3).SyncClient(time.Second)
+	for i := 0; i < 2*channelWindow+50; i++ { //more than window so server should grant credits
+		resp, err := ch.Call(context.Background(), &Message{Name: "ch", Payload: []byte("abc")})
+		if !as.Nil(err) {
+			return
+		}
+		as.Equal([]byte("ABC"), resp.Payload)
+		as.Equal(uint16(3), resp.Channel)
+	}
+	resp, err := cl.Call(context.Background(), &Message{Name: "default", Payload: []byte("x")})
+	as.Nil(err)
+	as.Equal([]byte("X"), resp.Payload)
+	as.Equal(uint16(0), resp.Channel)
+}
+
+func TestServerChannelsDedup(t *testing.T) {
+	as := assert.New(t)
+	s := &Server{Handler: HandlerFunc(upperHandler), DedupWindow: time.Minute}
+	defer s.Close()
+	a, b := net.Pipe()
+	go s.ServeConn(b)
+	cl, err := NewSyncClient(a, a, time.Second)
+	as.Nil(err)
+	defer cl.Shutdown()
+
+	//both clients number requests from 1, they are not duplicates of each other
+	resp, err := cl.Call(context.Background(), &Message{Name: "default", Payload: []byte("default")})
+	as.Nil(err)
+	as.Equal([]byte("DEFAULT"), resp.Payload)
+	resp, err = cl.Channel(1).SyncClient(time.Second).Call(context.Background(), &Message{Name: "ch", Payload: []byte("channel")})
+	as.Nil(err)
+	as.Equal([]byte("CHANNEL"), resp.Payload)
+	as.Equal(uint16(1), resp.Channel)
+}
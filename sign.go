@@ -0,0 +1,161 @@
+package fdstream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+)
+
+//maxKeyIDLen is a limit of key ID length in signature trailer
+const maxKeyIDLen = 255
+
+var (
+	//ErrUnsigned mean message has no signature but verifier require it
+	ErrUnsigned = errors.New("Message is not signed")
+	//ErrUnknownKey mean message is signed by key which is not in keyring
+	ErrUnknownKey = errors.New("Message is signed by unknown key")
+	//ErrBadSignature mean signature does not match message
+	ErrBadSignature = errors.New("Invalid message signature")
+	//ErrKeyIDTooLong mean key ID could not be encoded in trailer
+	ErrKeyIDTooLong = errors.New("Too long key ID")
+	//ErrSignatureLength mean signature is not HMAC-SHA256 so it could not be encoded in trailer
+	ErrSignatureLength = errors.New("Signature should be 32 bytes")
+)
+
+//Signer sign messages by HMAC-SHA256, KeyID is sent with signature so keys can be rotated
+type Signer struct {
+	KeyID string
+	Key   []byte
+}
+
+//Sign set signature of message code, ID, name and payload
+func (s Signer) Sign(m *Message) {
+	m.KeyID = s.KeyID
+	m.Signature = messageMAC(s.Key, m)
+}
+
+//Keyring is a set of keys by ID used to verify signatures
+type Keyring map[string][]byte
+
+//Verify check signature of message
+func (k Keyring) Verify(m *Message) error {
+	if len(m.Signature) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := k[m.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !hmac.Equal(m.Signature, messageMAC(key, m)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+//messageMAC calculate HMAC of signed fields, lengths are included so fields could not be shifted,
+// flags of stream frame are signed too so stream could not be cut
+func messageMAC(key []byte, m *Message) []byte {
+	var fixed [15]byte
+	fixed[0] = m.Code
+	binary.BigEndian.PutUint32(fixed[1:5], m.ID)
+	binary.BigEndian.PutUint16(fixed[5:7], uint16(len(m.Name)))
+	binary.BigEndian.PutUint64(fixed[7:15], uint64(len(m.Payload)))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fixed[:])
+	io.WriteString(mac, m.Name)
+	mac.Write(m.Payload)
+	io.WriteString(mac, m.KeyID)
+	if m.fragment&flagStream != 0 {
+		mac.Write([]byte{m.fragment})
+	}
+	return mac.Sum(nil)
+}
+
+//VerifyMode define what reader do with messages which fail verification
+type VerifyMode byte
+
+const (
+	//VerifyReject close connection with verification error
+	VerifyReject VerifyMode = iota
+	//VerifyFlag deliver message with Verified false
+	VerifyFlag
+)
+
+//WithSigner sign every sent message which is not signed yet and every frame of streams, control frames are not signed
+func WithSigner(s Signer) Option {
+	return func(cfg *config) {
+		cfg.signer = &s
+	}
+}
+
+//WithVerifier verify signatures of received messages by keyring, mode define reaction on invalid ones
+func WithVerifier(keys Keyring, mode VerifyMode) Option {
+	return func(cfg *config) {
+		cfg.keyring, cfg.verifyMode = keys, mode
+	}
+}
+
+//isControl check that message is a control frame of protocol
+func isControl(m *Message) bool {
+	switch m.Code {
+	case ctrlCreditCode, ctrlStreamCode, ctrlHelloCode, ctrlAuthCode:
+		return true
+	}
+	return false
+}
+
+//sign sign outgoing message if client has signer, fragments are not signed while frames of stream are
+func (c *AsyncClient) sign(m *Message) {
+	if c.signer != nil && len(m.Signature) == 0 && !isControl(m) && (m.fragment == 0 || m.fragment&flagStream != 0) {
+		c.signer.Sign(m)
+	}
+}
+
+//verify check received message, error mean connection should be closed
+func (c *AsyncClient) verify(m *Message) error {
+	if c.keyring == nil || isControl(m) {
+		return nil
+	}
+	err := c.keyring.Verify(m)
+	m.Verified = err == nil
+	if err != nil && c.verifyMode == VerifyFlag {
+		c.logger.log(levelError, "message is not verified", messageAttrs(m, slog.Any("error", err))...)
+		return nil
+	}
+	return err
+}
+
+//trailerLen is a length of signature trailer after payload
+func (m *Message) trailerLen() int {
+	if len(m.Signature) == 0 {
+		return 0
+	}
+	return 1 + len(m.KeyID) + len(m.Signature)
+}
+
+func (m *Message) writeTrailer(buf *bytes.Buffer) {
+	if len(m.Signature) == 0 {
+		return
+	}
+	buf.WriteByte(byte(len(m.KeyID)))
+	buf.WriteString(m.KeyID)
+	buf.Write(m.Signature)
+}
+
+//readTrailer read key ID and signature of signed message
+func (m *Message) readTrailer(r io.Reader, header []byte) error {
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return err
+	}
+	trailer := make([]byte, int(header[0])+sha256.Size)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return err
+	}
+	m.KeyID = string(trailer[:header[0]])
+	m.Signature = trailer[header[0]:]
+	return nil
+}
@@ -0,0 +1,107 @@
+package fdstream
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKeys = Keyring{"k1": []byte("first key"), "k2": []byte("second key")}
+
+func TestSignVerify(t *testing.T) {
+	as := assert.New(t)
+	m := &Message{Code: 3, ID: 9, Name: "order", Payload: []byte("42")}
+	Signer{KeyID: "k2", Key: testKeys["k2"]}.Sign(m)
+	as.Nil(testKeys.Verify(m))
+
+	b, err := m.Marshal()
+	as.Nil(err)
+	as.Equal(m.Len(), len(b))
+	got, err := unmarshal(b)
+	as.Nil(err)
+	as.Equal("k2", got.KeyID)
+	as.Nil(testKeys.Verify(&got))
+
+	got.Payload = []byte("43")
+	as.Equal(ErrBadSignature, testKeys.Verify(&got))
+	got.KeyID = "old"
+	as.Equal(ErrUnknownKey, testKeys.Verify(&got))
+	as.Equal(ErrUnsigned, testKeys.Verify(&Message{Name: "plain"}))
+}
+
+func TestSignedConnection(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t,
+		[]Option{WithSigner(Signer{KeyID: "k1", Key: testKeys["k1"]}), WithFragmentSize(1024)},
+		[]Option{WithVerifier(testKeys, VerifyReject)})
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	big := bytes.Repeat([]byte("x"), 5000)
+	a.ToSendQ <- &Message{Name: "small"}
+	a.ToSendQ <- &Message{Name: "big", Payload: big}
+	m := <-b.ToReadQ
+	as.Equal("small", m.Name)
+	as.True(m.Verified)
+	m = <-b.ToReadQ
+	as.Equal(big, m.Payload)
+	as.True(m.Verified)
+
+	//relayed message keep signature of origin
+	relay, c := newPeers(t, nil, []Option{WithVerifier(testKeys, VerifyReject)})
+	defer relay.Shutdown()
+	defer c.Shutdown()
+	relay.ToSendQ <- &Message{Name: m.Name, Payload: m.Payload, KeyID: m.KeyID, Signature: m.Signature}
+	m = <-c.ToReadQ
+	as.True(m.Verified)
+	as.Equal("k1", m.KeyID)
+}
+
+func TestVerifyModes(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, []Option{WithVerifier(testKeys, VerifyFlag)})
+	defer a.Shutdown()
+	defer b.Shutdown()
+	a.ToSendQ <- &Message{Name: "unsigned"}
+	m := <-b.ToReadQ
+	as.Equal("unsigned", m.Name)
+	as.False(m.Verified)
+
+	a, b = newPeers(t, []Option{WithSigner(Signer{KeyID: "k1", Key: []byte("forged")})},
+		[]Option{WithVerifier(testKeys, VerifyReject)})
+	defer a.Shutdown()
+	a.ToSendQ <- &Message{Name: "forged"}
+	<-b.Done()
+	as.Equal(ErrBadSignature, b.Err())
+}
+
+func TestSignatureLength(t *testing.T) {
+	as := assert.New(t)
+	_, err := (&Message{Name: "short", KeyID: "k1", Signature: []byte("short")}).Marshal()
+	as.Equal(ErrSignatureLength, err)
+}
+
+func TestSignedStream(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t,
+		[]Option{WithSigner(Signer{KeyID: "k1", Key: testKeys["k1"]}), WithFragmentSize(16)},
+		[]Option{WithVerifier(testKeys, VerifyReject)})
+	defer a.Shutdown()
+	defer b.Shutdown()
+	body := bytes.Repeat([]byte("signed "), 10)
+	go a.SendStream("signed", bytes.NewReader(body))
+	s, err := b.AcceptStream()
+	as.Nil(err)
+	got, err := ioutil.ReadAll(s)
+	as.Nil(err)
+	as.Equal(body, got)
+
+	//unsigned stream is rejected
+	a, b = newPeers(t, nil, []Option{WithVerifier(testKeys, VerifyReject)})
+	defer a.Shutdown()
+	go a.SendStream("unsigned", bytes.NewReader(body))
+	<-b.Done()
+	as.Equal(ErrUnsigned, b.Err())
+}
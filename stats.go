@@ -0,0 +1,205 @@
+package fdstream
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+//latencyBounds are upper bounds of call latency histogram buckets
+var latencyBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+//Histogram is a snapshot of distribution, Counts[i] is a count of values not bigger than Bounds[i],
+// last count is for values bigger than all bounds
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+//Mean return average value
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+//Stats is a snapshot of client counters
+type Stats struct {
+	MessagesSent     uint64
+	BytesSent        uint64
+	MessagesReceived uint64
+	BytesReceived    uint64
+	//SendQueue and ReadQueue are count of messages waiting in queues
+	SendQueue int
+	ReadQueue int
+	//LastActivity is a time of last written or read frame
+	LastActivity time.Time
+
+	//Fields of sync client
+	Calls        uint64
+	InFlight     int64
+	Timeouts     uint64
+	DuplicateIDs uint64
+	//Orphaned is a count of received responces which nobody wait yet
+	Orphaned int64
+	Latency  Histogram
+}
+
+//MetricsSink receive events of client, it should be fast and safe for concurrent use
+type MetricsSink interface {
+	//MessageSent is called for every written frame with its length
+	MessageSent(m *Message, bytes int)
+	//MessageReceived is called for every read frame with its length
+	MessageReceived(m *Message, bytes int)
+	//CallDone is called when sync call is finished
+	CallDone(m *Message, latency time.Duration, err error)
+}
+
+//WithMetrics send events of client to sink
+func WithMetrics(sink MetricsSink) Option {
+	return func(cfg *config) {
+		cfg.metrics = sink
+	}
+}
+
+//add sum counters and queue depths of other stats to s
+func (s *Stats) add(o *Stats) {
+	s.MessagesSent += o.MessagesSent
+	s.BytesSent += o.BytesSent
+	s.MessagesReceived += o.MessagesReceived
+	s.BytesReceived += o.BytesReceived
+	s.SendQueue += o.SendQueue
+	s.ReadQueue += o.ReadQueue
+	if o.LastActivity.After(s.LastActivity) {
+		s.LastActivity = o.LastActivity
+	}
+}
+
+//clientStats are counters of async client updated atomically
+type clientStats struct {
+	messagesSent     uint64
+	bytesSent        uint64
+	messagesReceived uint64
+	bytesReceived    uint64
+	lastActivity     int64 //unix nano
+}
+
+//callStats are counters of sync client
+type callStats struct {
+	calls        uint64
+	timeouts     uint64
+	duplicateIDs uint64
+	inFlight     int64
+	orphaned     int64
+	latencySum   int64
+	latency      []uint64 //count per bucket of latencyBounds and one more
+}
+
+func newCallStats() *callStats {
+	return &callStats{latency: make([]uint64, len(latencyBounds)+1)}
+}
+
+//sent count written frame, continuation fragments are counted only by bytes
+func (c *AsyncClient) sent(m *Message) {
+	n := m.Len()
+	atomic.AddUint64(&c.stats.bytesSent, uint64(n))
+	atomic.StoreInt64(&c.stats.lastActivity, time.Now().UnixNano())
+	if m.fragment&flagContinuation == 0 {
+		atomic.AddUint64(&c.stats.messagesSent, 1)
+	}
+	if c.metrics != nil {
+		c.metrics.MessageSent(m, n)
+	}
+	if c.capture != nil {
+		c.capture.record(DirectionOut, m)
+	}
+}
+
+//received count read frame, continuation fragments are counted only by bytes
+func (c *AsyncClient) received(m *Message) {
+	n := m.Len()
+	atomic.AddUint64(&c.stats.bytesReceived, uint64(n))
+	atomic.StoreInt64(&c.stats.lastActivity, time.Now().UnixNano())
+	if m.fragment&flagContinuation == 0 {
+		atomic.AddUint64(&c.stats.messagesReceived, 1)
+	}
+	if c.metrics != nil {
+		c.metrics.MessageReceived(m, n)
+	}
+	if c.capture != nil {
+		c.capture.record(DirectionIn, m)
+	}
+}
+
+//Stats return snapshot of client counters
+func (c *AsyncClient) Stats() Stats {
+	s := Stats{
+		MessagesSent:     atomic.LoadUint64(&c.stats.messagesSent),
+		BytesSent:        atomic.LoadUint64(&c.stats.bytesSent),
+		MessagesReceived: atomic.LoadUint64(&c.stats.messagesReceived),
+		BytesReceived:    atomic.LoadUint64(&c.stats.bytesReceived),
+		ReadQueue:        len(c.ToReadQ),
+	}
+	if last := atomic.LoadInt64(&c.stats.lastActivity); last != 0 {
+		s.LastActivity = time.Unix(0, last)
+	}
+	for _, q := range c.sendQs {
+		s.SendQueue += len(q)
+	}
+	if c.flow != nil {
+		s.ReadQueue += len(c.flow.inbox)
+	}
+	return s
+}
+
+//callDone count finished call
+func (sync *SyncClient) callDone(m *Message, start time.Time, err error) {
+	d := time.Since(start)
+	s := sync.callStats
+	atomic.AddUint64(&s.calls, 1)
+	atomic.AddInt64(&s.latencySum, int64(d))
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&s.latency[i], 1)
+	switch err {
+	case ErrTimeout, context.DeadlineExceeded:
+		atomic.AddUint64(&s.timeouts, 1)
+	default:
+		if code, ok := ErrorCode(err); ok && code == CodeDuplicateID {
+			atomic.AddUint64(&s.duplicateIDs, 1)
+		}
+	}
+	if sync.metrics != nil {
+		sync.metrics.CallDone(m, d, err)
+	}
+}
+
+//Stats return snapshot of client counters including calls
+func (sync *SyncClient) Stats() Stats {
+	st := sync.AsyncClient.Stats()
+	s := sync.callStats
+	st.Calls = atomic.LoadUint64(&s.calls)
+	st.InFlight = atomic.LoadInt64(&s.inFlight)
+	st.Timeouts = atomic.LoadUint64(&s.timeouts)
+	st.DuplicateIDs = atomic.LoadUint64(&s.duplicateIDs)
+	st.Orphaned = atomic.LoadInt64(&s.orphaned)
+	st.Latency = Histogram{
+		Bounds: latencyBounds,
+		Counts: make([]uint64, len(s.latency)),
+		Sum:    time.Duration(atomic.LoadInt64(&s.latencySum)),
+	}
+	for i := range s.latency {
+		st.Latency.Counts[i] = atomic.LoadUint64(&s.latency[i])
+		st.Latency.Count += st.Latency.Counts[i]
+	}
+	return st
+}
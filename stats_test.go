@@ -0,0 +1,107 @@
+package fdstream
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingSink struct {
+	l                     sync.Mutex
+	sent, received, calls int
+}
+
+func (s *countingSink) MessageSent(m *Message, bytes int) {
+	s.l.Lock()
+	s.sent++
+	s.l.Unlock()
+}
+
+func (s *countingSink) MessageReceived(m *Message, bytes int) {
+	s.l.Lock()
+	s.received++
+	s.l.Unlock()
+}
+
+func (s *countingSink) CallDone(m *Message, latency time.Duration, err error) {
+	s.l.Lock()
+	s.calls++
+	s.l.Unlock()
+}
+
+func TestSyncClientStats(t *testing.T) {
+	as := assert.New(t)
+	sink := new(countingSink)
+	a, b, err := SyncPipe(100*time.Millisecond, WithClientOptions(WithMetrics(sink)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	go echoPeer(b)
+
+	for i := 0; i < 3; i++ {
+		_, err := a.Call(context.Background(), &Message{Name: "ping", Payload: []byte("pong")})
+		as.Nil(err)
+	}
+	msgLen := (&Message{Name: "ping", Payload: []byte("pong")}).Len()
+	as.True(waitFor(func() bool { return a.Stats().MessagesSent == 3 }))
+
+	s := a.Stats()
+	as.Equal(uint64(3), s.MessagesSent)
+	as.Equal(uint64(3*msgLen), s.BytesSent)
+	as.Equal(uint64(3), s.MessagesReceived)
+	as.Equal(uint64(3*msgLen), s.BytesReceived)
+	as.Equal(uint64(3), s.Calls)
+	as.Equal(int64(0), s.InFlight)
+	as.Equal(uint64(3), s.Latency.Count)
+	as.Len(s.Latency.Counts, len(s.Latency.Bounds)+1)
+	as.True(s.Latency.Mean() > 0)
+
+	sink.l.Lock()
+	as.Equal(6, sink.sent) //both sides share sink
+	as.Equal(6, sink.received)
+	as.Equal(3, sink.calls)
+	sink.l.Unlock()
+}
+
+func TestSyncClientStatsTimeout(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(50 * time.Millisecond)
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	_, err = a.Call(context.Background(), &Message{Name: "lost"})
+	as.Equal(ErrTimeout, err)
+	m := <-b.ToReadQ
+	b.ToSendQ <- &Message{ID: m.ID, Name: "late"}
+
+	s := a.Stats()
+	as.Equal(uint64(1), s.Calls)
+	as.Equal(uint64(1), s.Timeouts)
+	as.Equal(uint64(1), s.Latency.Count)
+	as.Equal(uint64(0), s.Latency.Counts[0])
+
+	as.True(waitFor(func() bool { return a.Stats().Orphaned == 1 }))
+}
+
+func TestAsyncClientStatsFragments(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := Pipe(WithClientOptions(WithFragmentSize(1024)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	a.ToSendQ <- &Message{Name: "big", Payload: make([]byte, 4096)}
+	m := <-b.ToReadQ
+	as.Len(m.Payload, 4096)
+
+	as.True(waitFor(func() bool { return a.Stats().BytesSent == b.Stats().BytesReceived }))
+	as.Equal(uint64(1), a.Stats().MessagesSent)
+	s := b.Stats()
+	as.Equal(uint64(1), s.MessagesReceived)
+	as.True(s.BytesReceived > 4096)
+	as.Equal(0, s.ReadQueue)
+}
@@ -0,0 +1,248 @@
+package fdstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	//streamWindow is a count of chunks sender can write to stream before reader get them
+	streamWindow = 8
+	//ctrlStreamCode is a code of control message for stream with ID of message, it is marked by stream flag,
+	// payload is uint32 count of chunks reader is ready to receive, empty payload mean reader closed the stream
+	ctrlStreamCode byte = 249
+)
+
+var (
+	//ErrStreamClosed mean stream was closed by reader before all data was sent
+	ErrStreamClosed = errors.New("Stream closed by reader")
+	//ErrTooManyStreams mean peer opened more streams than were accepted
+	ErrTooManyStreams = errors.New("Too many not accepted streams")
+)
+
+//streamChunk is a part of stream body, last chunk has io.EOF or error of sender
+type streamChunk struct {
+	data []byte
+	err  error
+}
+
+//Stream is an incoming body sent by SendStream, it is read by chunks with bounded memory
+type Stream struct {
+	//Name of stream given by sender
+	Name string
+	//ID is unique for streams of single sender
+	ID uint32
+
+	client *AsyncClient
+	chunks chan streamChunk
+	buf    []byte
+	err    error
+	closed int32
+}
+
+//outStream is a sending side of stream
+type outStream struct {
+	window *credit
+	cancel context.CancelFunc
+}
+
+//SendStream send body from r as a stream of fragments, reader get it by AcceptStream
+// only few chunks are in flight, so it wait while reader is slow, error of r is passed to reader
+func (c *AsyncClient) SendStream(name string, r io.Reader) error {
+	if err := c.negotiate(FeatureStreams); err != nil {
+		return err
+	}
+	id := atomic.AddUint32(&c.streamCounter, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &outStream{window: newCredit(streamWindow), cancel: cancel}
+	c.streamsLock.Lock()
+	c.outStreams[id] = out
+	c.streamsLock.Unlock()
+	defer func() {
+		c.streamsLock.Lock()
+		delete(c.outStreams, id)
+		c.streamsLock.Unlock()
+	}()
+
+	first := true
+	for {
+		buf := make([]byte, c.fragmentSize)
+		n, err := r.Read(buf)
+		frame := &Message{ID: id, fragment: flagStream | flagMore}
+		if first {
+			frame.Name = name
+		} else {
+			frame.fragment |= flagContinuation
+		}
+		if n > 0 {
+			if aerr := out.window.acquire(ctx, c.kill, 1); aerr != nil {
+				if aerr == context.Canceled {
+					return ErrStreamClosed
+				}
+				return aerr
+			}
+			frame.Payload = buf[:n]
+			if serr := c.Send(frame); serr != nil {
+				return serr
+			}
+			first = false
+			frame = &Message{ID: id, fragment: flagStream | flagContinuation | flagMore}
+		}
+		if err == nil {
+			continue
+		}
+
+		frame.fragment &^= flagMore //last frame
+		if err != io.EOF {
+			frame.Code, frame.Payload = CodeGeneralError, []byte(err.Error())
+		}
+		if serr := c.Send(frame); serr != nil {
+			return serr
+		}
+		if err != io.EOF {
+			return err
+		}
+		return nil
+	}
+}
+
+//AcceptStream wait next incoming stream
+func (c *AsyncClient) AcceptStream() (*Stream, error) {
+	select {
+	case s := <-c.streams:
+		return s, nil
+	case <-c.kill:
+		return nil, ErrConnectionClosed
+	}
+}
+
+//streamFrame route stream frame from reader to incoming stream or stream control to outgoing one
+func (c *AsyncClient) streamFrame(m *Message) error {
+	if m.Code == ctrlStreamCode {
+		c.streamControl(m)
+		return nil
+	}
+	c.streamsLock.Lock()
+	s, ok := c.inStreams[m.ID]
+	if m.fragment&flagContinuation == 0 {
+		if ok {
+			c.streamsLock.Unlock()
+			return ErrFragmentSequence
+		}
+		s = &Stream{
+			Name:   m.Name,
+			ID:     m.ID,
+			client: c,
+			chunks: make(chan streamChunk, streamWindow+1),
+		}
+		select {
+		case c.streams <- s:
+		default:
+			c.streamsLock.Unlock()
+			return ErrTooManyStreams
+		}
+		c.inStreams[m.ID], ok = s, true
+	}
+	if m.fragment&flagMore == 0 {
+		delete(c.inStreams, m.ID)
+	}
+	c.streamsLock.Unlock()
+	if !ok {
+		return nil //stream was closed by reader
+	}
+
+	if len(m.Payload) > 0 && m.Code != CodeGeneralError {
+		select {
+		case s.chunks <- streamChunk{data: m.Payload}:
+		default:
+			return ErrWindowExceeded
+		}
+	}
+	if m.fragment&flagMore == 0 {
+		chunk := streamChunk{err: io.EOF}
+		if m.Code == CodeGeneralError {
+			chunk.err = &ResponseError{Code: m.Code, Text: string(m.Payload)}
+		}
+		select {
+		case s.chunks <- chunk:
+		default:
+			return ErrWindowExceeded
+		}
+	}
+	return nil
+}
+
+//streamControl handle credits and cancel of outgoing stream
+func (c *AsyncClient) streamControl(m *Message) {
+	c.streamsLock.Lock()
+	out, ok := c.outStreams[m.ID]
+	c.streamsLock.Unlock()
+	if !ok {
+		return
+	}
+	if len(m.Payload) < 4 {
+		out.cancel()
+		return
+	}
+	out.window.add(int64(binary.BigEndian.Uint32(m.Payload)))
+}
+
+func newStreamControl(id uint32, chunks uint32) *Message {
+	m := &Message{Code: ctrlStreamCode, ID: id, fragment: flagStream}
+	if chunks > 0 {
+		m.Payload = make([]byte, 4)
+		binary.BigEndian.PutUint32(m.Payload, chunks)
+	}
+	return m
+}
+
+//Read implements io.Reader, it return sender error or io.EOF at the end of stream
+func (s *Stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var chunk streamChunk
+		select {
+		case chunk = <-s.chunks:
+		default:
+			select {
+			case chunk = <-s.chunks:
+			case <-s.client.kill:
+				s.err = ErrConnectionClosed
+				continue
+			}
+		}
+		if chunk.err != nil {
+			s.err = chunk.err
+			continue
+		}
+		s.buf = chunk.data
+		if atomic.LoadInt32(&s.closed) == 0 {
+			s.client.writeControl(newStreamControl(s.ID, 1))
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+//Close stop reading of stream, sender get ErrStreamClosed if it is still sending
+func (s *Stream) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	c := s.client
+	c.streamsLock.Lock()
+	_, active := c.inStreams[s.ID]
+	delete(c.inStreams, s.ID)
+	c.streamsLock.Unlock()
+	if active {
+		c.writeControl(newStreamControl(s.ID, 0))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package fdstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamCopy(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithFragmentSize(4096)}, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	body := bytes.Repeat([]byte("stream body "), 1e5)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- a.SendStream("file", bytes.NewReader(body))
+	}()
+	a.ToSendQ <- &Message{Name: "regular"}
+
+	s, err := b.AcceptStream()
+	as.Nil(err)
+	as.Equal("file", s.Name)
+	got, err := ioutil.ReadAll(s)
+	as.Nil(err)
+	as.Equal(body, got)
+	as.Nil(<-sent)
+	as.Equal("regular", (<-b.ToReadQ).Name)
+}
+
+//countingReader count reads of endless source
+type countingReader struct {
+	reads int32
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return len(p), nil
+}
+
+func TestStreamBoundedAndClose(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, []Option{WithFragmentSize(1024)}, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	src := &countingReader{}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- a.SendStream("endless", src)
+	}()
+	s, err := b.AcceptStream()
+	as.Nil(err)
+	time.Sleep(50 * time.Millisecond)
+	as.Equal(int32(streamWindow+1), atomic.LoadInt32(&src.reads))
+
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(s, buf)
+	as.Nil(err)
+	as.True(waitFor(func() bool { return atomic.LoadInt32(&src.reads) == streamWindow+2 }))
+
+	as.Nil(s.Close())
+	as.Equal(ErrStreamClosed, <-sent)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("disk failure")
+}
+
+func TestStreamSenderError(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	go a.SendStream("broken", io.MultiReader(bytes.NewReader([]byte("head")), failingReader{}))
+	s, err := b.AcceptStream()
+	as.Nil(err)
+	got, err := ioutil.ReadAll(s)
+	as.Equal([]byte("head"), got)
+	as.Equal(&ResponseError{Code: CodeGeneralError, Text: "disk failure"}, err)
+}
+
+func TestStreamConnectionClosed(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer b.Shutdown()
+
+	go a.SendStream("cut", &countingReader{})
+	s, err := b.AcceptStream()
+	as.Nil(err)
+	a.Shutdown()
+	_, err = ioutil.ReadAll(s)
+	as.Equal(ErrConnectionClosed, err)
+
+	_, err = b.AcceptStream()
+	as.Equal(ErrConnectionClosed, err)
+}
+
+func TestStreamCodeMessage(t *testing.T) {
+	as := assert.New(t)
+	a, b := newPeers(t, nil, nil)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	//message with stream control code is not a stream frame
+	a.ToSendQ <- &Message{ID: 1, Code: ctrlStreamCode, Name: "code", Payload: []byte("error text")}
+	m := b.Read()
+	as.Equal(ctrlStreamCode, m.Code)
+	as.Equal([]byte("error text"), m.Payload)
+}
@@ -1,6 +1,7 @@
 package fdstream
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
@@ -13,8 +14,40 @@ var (
 	ErrMessageTimeout = &Message{Code: erTimeoutCode, Name: "Timeout on waiting message"}
 	//ErrMessageDuplicateID indicate sync client already wait message with same name
 	ErrMessageDuplicateID = &Message{Code: erDuplicateIDErrorCode, Name: "Message with same id already wait response"}
+	//ErrMessageConnectionClosed indicate connection was closed before responce come
+	ErrMessageConnectionClosed = &Message{Code: erConnectionClosedCode, Name: "Connection closed"}
+
+	//ErrConnectionClosed is returned for calls which can't be finished because client is shut down
+	ErrConnectionClosed = errors.New(ErrMessageConnectionClosed.Name)
+	//ErrTimeout is returned for calls which did not get responce in time
+	ErrTimeout = errors.New(ErrMessageTimeout.Name)
 )
 
+//ResponseError is an error responce of remote side
+type ResponseError struct {
+	Code byte
+	Text string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Text
+}
+
+//ErrorCode return code of error returned by call
+func ErrorCode(err error) (byte, bool) {
+	switch e := err.(type) {
+	case *ResponseError:
+		return e.Code, true
+	}
+	switch err {
+	case ErrTimeout:
+		return CodeTimeout, true
+	case ErrConnectionClosed:
+		return CodeConnectionClosed, true
+	}
+	return 0, false
+}
+
 type messageWithTimeout struct {
 	message *Message
 	timeout int64
@@ -24,6 +57,7 @@ type messageReceiver struct {
 	responce chan *Message
 	id       uint32
 	timeout  int64
+	cancel   *messageReceiver //receiver which call is cancelled, it is removed instead of added
 }
 
 var (
@@ -48,26 +82,42 @@ type SyncClient struct {
 	awaitMessageQ   chan *messageReceiver
 	unknownMessage  map[uint32]*messageWithTimeout
 	messageToReturn map[uint32]*messageReceiver
+	stopped         chan struct{}
+	readQ           <-chan *Message
+	received        func() //optional notification about message taken from readQ
+	write           func(ctx context.Context, m *Message) error
+	callStats       *callStats
+	invoke          Invoker //send wrapped by interceptors
 }
 
 //NewSyncClient create sync handler it have sync read from stream
-func NewSyncClient(outcome io.WriteCloser, income io.ReadCloser, timeout time.Duration) (*SyncClient, error) {
-	asyncClient, err := NewAsyncClient(outcome, income)
+func NewSyncClient(outcome io.WriteCloser, income io.ReadCloser, timeout time.Duration, opts ...Option) (*SyncClient, error) {
+	asyncClient, err := NewAsyncClient(outcome, income, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	c := newSyncClient(asyncClient, asyncClient.ToReadQ, timeout)
+	go c.synchronizationWorker()
+	return c, nil
+}
+
+//newSyncClient create sync client which read responces from readQ
+func newSyncClient(asyncClient *AsyncClient, readQ <-chan *Message, timeout time.Duration) *SyncClient {
 	c := &SyncClient{
 		unknownMessage:  make(map[uint32]*messageWithTimeout, 10*defaultQSize),
 		messageToReturn: make(map[uint32]*messageReceiver, 20*defaultQSize),
 		awaitMessageQ:   make(chan *messageReceiver, defaultQSize),
+		stopped:         make(chan struct{}),
 		counter:         new(uint32),
 		defaultTimeout:  timeout,
 		AsyncClient:     asyncClient,
+		readQ:           readQ,
+		callStats:       newCallStats(),
 	}
-
-	go c.synchronizationWorker()
-	return c, nil
+	c.write = c.enqueue
+	c.invoke = chainInvoker(c.send, asyncClient.interceptors)
+	return c
 }
 
 func (sync *SyncClient) synchronizationWorker() {
@@ -84,14 +134,18 @@ func (sync *SyncClient) synchronizationWorker() {
 
 	for asyncClient.IsAlive() {
 		select {
+		case <-asyncClient.kill:
+			//IsAlive will stop the loop
 		case <-janitorTicker.C: //cleanup old messages and responce waiters
 			now = time.Now().UnixNano()
 			for id, mwt = range sync.unknownMessage {
 				if mwt.timeout > now {
 					continue
 				}
+				sync.logger.log(levelDrop, "responce dropped", messageAttrs(mwt.message)...)
 				delete(sync.unknownMessage, id)
 			}
+			atomic.StoreInt64(&sync.callStats.orphaned, int64(len(sync.unknownMessage)))
 			for id, mr = range sync.messageToReturn { //fire timeout
 				if mr.timeout > now {
 					continue
@@ -102,10 +156,17 @@ func (sync *SyncClient) synchronizationWorker() {
 
 		case r := <-sync.awaitMessageQ: //add messageReceiver to wait responce from back side
 			id = r.id
+			if r.cancel != nil {
+				if sync.messageToReturn[id] == r.cancel {
+					delete(sync.messageToReturn, id)
+				}
+				continue
+			}
 			if mwt, ok = sync.unknownMessage[id]; ok {
 				r.responce <- mwt.message
 				messageWaiterPool.Put(mwt)
 				delete(sync.unknownMessage, id)
+				atomic.StoreInt64(&sync.callStats.orphaned, int64(len(sync.unknownMessage)))
 				continue
 			}
 			if _, ok = sync.messageToReturn[id]; ok { //TODO maybe just remove check
@@ -114,7 +175,10 @@ func (sync *SyncClient) synchronizationWorker() {
 			}
 			r.timeout = time.Now().Add(sync.defaultTimeout).UnixNano()
 			sync.messageToReturn[id] = r
-		case m := <-asyncClient.ToReadQ: //read income messages
+		case m := <-sync.readQ: //read income messages
+			if sync.received != nil {
+				sync.received()
+			}
 			id = m.ID
 
 			if mr, ok = sync.messageToReturn[id]; ok {
@@ -126,19 +190,34 @@ func (sync *SyncClient) synchronizationWorker() {
 			waitMessage.message = m
 			waitMessage.timeout = time.Now().Add(sync.defaultTimeout).UnixNano()
 			sync.unknownMessage[id] = waitMessage
+			atomic.StoreInt64(&sync.callStats.orphaned, int64(len(sync.unknownMessage)))
 		}
 	}
 	// fail rest messages
-	for id, mr = range sync.messageToReturn { //fire timeout
-		mr.responce <- ErrMessageTimeout
+	for id, mr = range sync.messageToReturn {
+		mr.responce <- ErrMessageConnectionClosed
+		delete(sync.messageToReturn, id)
 	}
-	for mr = range sync.awaitMessageQ {
-		mr.responce <- ErrMessageTimeout
+	close(sync.stopped)
+	for {
+		select {
+		case mr = <-sync.awaitMessageQ:
+			if mr.cancel == nil {
+				mr.responce <- ErrMessageConnectionClosed
+			}
+		default:
+			return
+		}
 	}
 }
 
 //WriteAndReadResponce will write message and expect responce or error
 func (sync *SyncClient) WriteAndReadResponce(m *Message) (*Message, error) {
+	return sync.Call(context.Background(), m)
+}
+
+//Call will write message and expect responce or error until ctx is done
+func (sync *SyncClient) Call(ctx context.Context, m *Message) (*Message, error) {
 	if m == nil {
 		return nil, errNilMessage
 	}
@@ -147,19 +226,95 @@ func (sync *SyncClient) WriteAndReadResponce(m *Message) (*Message, error) {
 	if len(m.Name) == 0 {
 		return nil, ErrEmptyName
 	}
-	sync.AsyncClient.ToSendQ <- m
-	return sync.read(m.ID)
+	return sync.invoke(ctx, m)
+}
+
+//CallWithRetry call and repeat message with same ID while error is retryable according policy
+// server with de-duplication window execute not idempotent message only once
+func (sync *SyncClient) CallWithRetry(ctx context.Context, m *Message, policy RetryPolicy) (*Message, error) {
+	return policy.do(ctx, m, func(attempt int) (*Message, error) {
+		if attempt == 0 {
+			return sync.Call(ctx, m)
+		}
+		return sync.invoke(ctx, m)
+	})
+}
+
+//send write message with already assigned ID and wait responce
+func (sync *SyncClient) send(ctx context.Context, m *Message) (r *Message, err error) {
+	start := time.Now()
+	if sync.tracer != nil {
+		ctx = sync.tracer.Start(ctx, m)
+	}
+	injectTraceparent(ctx, m)
+	atomic.AddInt64(&sync.callStats.inFlight, 1)
+	defer func() {
+		atomic.AddInt64(&sync.callStats.inFlight, -1)
+		sync.callDone(m, start, err)
+		if err == ErrTimeout || err == context.DeadlineExceeded {
+			sync.logger.log(levelTimeout, "call timeout", messageAttrs(m)...)
+		}
+		if sync.tracer != nil {
+			sync.tracer.End(ctx, m, time.Since(start), err)
+		}
+	}()
+	if err = sync.write(ctx, m); err != nil {
+		return nil, err
+	}
+	return sync.readContext(ctx, m.ID)
+}
+
+//enqueue put message to send queue of async client
+func (sync *SyncClient) enqueue(ctx context.Context, m *Message) error {
+	if err := sync.AsyncClient.check(m); err != nil {
+		return err
+	}
+	select {
+	case sync.AsyncClient.queue(m.Priority) <- m:
+		return nil
+	case <-sync.stopped:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 //read is 'wait and read' message by specified id
 func (sync *SyncClient) read(id uint32) (*Message, error) {
+	return sync.readContext(context.Background(), id)
+}
+
+func (sync *SyncClient) readContext(ctx context.Context, id uint32) (mes *Message, err error) {
 	getter := messageReceiverPool.Get().(*messageReceiver)
 	getter.id = id
-	sync.awaitMessageQ <- getter
-	mes := <-getter.responce
-	messageReceiverPool.Put(getter)
+	select {
+	case sync.awaitMessageQ <- getter:
+	case <-sync.stopped:
+		messageReceiverPool.Put(getter)
+		return nil, ErrConnectionClosed
+	}
+	select {
+	case mes = <-getter.responce:
+		messageReceiverPool.Put(getter)
+	case <-sync.stopped:
+		//getter could be still referenced by worker so do not return it to pool
+		return nil, ErrConnectionClosed
+	case <-ctx.Done():
+		//remove receiver so call can be repeated with same ID, getter is not returned to pool too
+		select {
+		case sync.awaitMessageQ <- &messageReceiver{id: id, cancel: getter}:
+		case <-sync.stopped:
+		}
+		return nil, ctx.Err()
+	}
 	if mes.Code < 200 {
 		return mes, nil
 	}
-	return nil, errors.New(mes.Name)
+	switch mes {
+	case ErrMessageConnectionClosed:
+		return nil, ErrConnectionClosed
+	case ErrMessageTimeout:
+		return nil, ErrTimeout
+	}
+	return nil, &ResponseError{Code: mes.Code, Text: mes.Name}
 }
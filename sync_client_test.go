@@ -2,6 +2,7 @@ package fdstream
 
 import (
 	"bytes"
+	"context"
 	"strconv"
 	"testing"
 	"time"
@@ -56,7 +57,7 @@ func TestSyncWrite(t *testing.T) {
 
 func TestSyncRead(t *testing.T) {
 	as := assert.New(t)
-	data, _ := (&Message{"name", 0, byte(0), []byte("anry")}).Marshal()
+	data, _ := (&Message{Name: "name", Code: byte(0), Payload: []byte("anry")}).Marshal()
 	readCloser := &TestReaderWaiter{
 		data: data,
 		d:    time.Duration(200 * time.Millisecond), //Wait reader for test writer
@@ -78,3 +79,27 @@ func TestSyncRead(t *testing.T) {
 	handler.Shutdown() //Stop loops
 
 }
+
+func TestSyncCallCancelled(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := SyncPipe(time.Second)
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	m := &Message{Name: "slow"}
+	_, err = a.Call(ctx, m)
+	as.Equal(context.DeadlineExceeded, err)
+	<-b.ToReadQ
+
+	//cancelled receiver is removed, so call can be repeated with same ID
+	go func() {
+		req := <-b.ToReadQ
+		b.ToSendQ <- &Message{ID: req.ID, Name: "done"}
+	}()
+	resp, err := a.invoke(context.Background(), m)
+	as.Nil(err)
+	as.Equal("done", resp.Name)
+}
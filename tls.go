@@ -0,0 +1,125 @@
+package fdstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+//handshakeTimeout limit TLS handshake of accepted connection
+const handshakeTimeout = 10 * time.Second
+
+//ErrNoCertificates mean CA file does not contain any certificate
+var ErrNoCertificates = errors.New("No certificates in CA file")
+
+//ListenAndServeTLS listen TCP address and serve TLS connections by handler
+func ListenAndServeTLS(addr string, config *tls.Config, handler Handler) error {
+	s := &Server{Handler: handler}
+	return s.ListenAndServeTLS(addr, config)
+}
+
+//ListenAndServeTLS listen TCP address and serve TLS connections
+func (s *Server) ListenAndServeTLS(addr string, config *tls.Config) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, config)
+}
+
+//ServeTLS accept TLS connections from listener until it is closed,
+// peer certificate verified by config is available to handler by PeerFromContext
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(l, config))
+}
+
+//DialTLS connect to address and finish TLS handshake
+func DialTLS(ctx context.Context, addr string, config *tls.Config) (*tls.Conn, error) {
+	d := tls.Dialer{Config: config}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*tls.Conn), nil
+}
+
+//TLSDialer return dialer of TLS connections for ReconnectingClient, Pool or MultiClient
+func TLSDialer(config *tls.Config) AddrDialer {
+	return func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		return DialTLS(ctx, addr, config)
+	}
+}
+
+//MutualTLSConfig load certificate with key and CA which verify certificate of peer,
+// for server it require client certificate, for client it verify server one
+func MutualTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrNoCertificates
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+//Peer describe remote side of server connection
+type Peer struct {
+	//Addr is a remote address if connection is net.Conn
+	Addr net.Addr
+	//Certificate is a verified certificate of TLS client
+	Certificate *x509.Certificate
+	//Identity is a common name or first DNS name of certificate, it is empty without verified certificate
+	Identity string
+}
+
+type peerKey struct{}
+
+//PeerFromContext return peer of connection which message came from
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+//newPeer describe connection, TLS handshake is finished to get certificate
+func newPeer(rw io.ReadWriteCloser) (*Peer, error) {
+	p := &Peer{}
+	if conn, ok := rw.(net.Conn); ok {
+		p.Addr = conn.RemoteAddr()
+	}
+	conn, ok := rw.(*tls.Conn)
+	if !ok {
+		return p, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	//certificate which is not verified (like with tls.RequireAnyClientCert) give no identity
+	if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		cert := chains[0][0]
+		p.Certificate = cert
+		p.Identity = cert.Subject.CommonName
+		if p.Identity == "" && len(cert.DNSNames) > 0 {
+			p.Identity = cert.DNSNames[0]
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,151 @@
+package fdstream
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+//writeFiles save certificate and key as PEM files
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	as := assert.New(t)
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	serverCert, serverKey := newTestCert(t, "server", ca).writeFiles(t, dir, "server")
+	serverConfig, err := MutualTLSConfig(serverCert, serverKey, caFile)
+	as.Nil(err)
+	clientCert, clientKey := newTestCert(t, "worker-1", ca).writeFiles(t, dir, "client")
+	clientConfig, err := MutualTLSConfig(clientCert, clientKey, caFile)
+	as.Nil(err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.Nil(err)
+	s := &Server{Handler: HandlerFunc(func(m *Message) *Message {
+		peer, ok := PeerFromContext(m.Context())
+		if !ok || peer.Certificate == nil {
+			return &Message{Code: CodeGeneralError, Name: "no peer"}
+		}
+		return &Message{Name: m.Name, Payload: []byte(peer.Identity)}
+	})}
+	go s.ServeTLS(l, serverConfig)
+	defer s.Close()
+
+	conn, err := DialTLS(context.Background(), l.Addr().String(), clientConfig)
+	as.Nil(err)
+	c, err := NewSyncClient(conn, conn, time.Second)
+	as.Nil(err)
+	defer c.Shutdown()
+	m, err := c.WriteAndReadResponce(&Message{Name: "who"})
+	as.Nil(err)
+	as.Equal([]byte("worker-1"), m.Payload)
+
+	//client without certificate is rejected
+	anonymous := &tls.Config{RootCAs: clientConfig.RootCAs}
+	rw, err := TLSDialer(anonymous)(context.Background(), l.Addr().String())
+	if err == nil {
+		c, err = NewSyncClient(rw, rw, time.Second)
+		as.Nil(err)
+		defer c.Shutdown()
+		_, err = c.WriteAndReadResponce(&Message{Name: "who"})
+	}
+	as.NotNil(err)
+}
+
+func TestServeUnverifiedTLS(t *testing.T) {
+	as := assert.New(t)
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	serverCert, serverKey := newTestCert(t, "server", ca).writeFiles(t, dir, "server")
+	serverConfig, err := MutualTLSConfig(serverCert, serverKey, caFile)
+	as.Nil(err)
+	serverConfig.ClientAuth, serverConfig.ClientCAs = tls.RequireAnyClientCert, nil
+	clientCert, clientKey := newTestCert(t, "worker-1", nil).writeFiles(t, dir, "client") //self-signed
+	clientConfig, err := MutualTLSConfig(clientCert, clientKey, caFile)
+	as.Nil(err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.Nil(err)
+	s := &Server{Handler: HandlerFunc(func(m *Message) *Message {
+		peer, _ := PeerFromContext(m.Context())
+		if peer.Certificate != nil {
+			return &Message{Code: CodeGeneralError, Name: "unverified certificate"}
+		}
+		return &Message{Name: m.Name, Payload: []byte(peer.Identity)}
+	})}
+	go s.ServeTLS(l, serverConfig)
+	defer s.Close()
+
+	conn, err := DialTLS(context.Background(), l.Addr().String(), clientConfig)
+	as.Nil(err)
+	c, err := NewSyncClient(conn, conn, time.Second)
+	as.Nil(err)
+	defer c.Shutdown()
+	m, err := c.WriteAndReadResponce(&Message{Name: "who"})
+	as.Nil(err)
+	as.Empty(m.Payload)
+}
+
+func TestMutualTLSConfigErrors(t *testing.T) {
+	as := assert.New(t)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "ca", nil).writeFiles(t, dir, "ca")
+	_, err := MutualTLSConfig(certFile, keyFile, keyFile)
+	as.Equal(ErrNoCertificates, err)
+	_, err = MutualTLSConfig(certFile, keyFile, filepath.Join(dir, "missing"))
+	as.NotNil(err)
+}
@@ -0,0 +1,94 @@
+package fdstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+//TraceparentKey is a metadata key of W3C trace context of message
+const TraceparentKey = "traceparent"
+
+//Tracer observe calls of sync client and requests handled by server,
+// it can bridge fdstream to OpenTelemetry or own tracer
+type Tracer interface {
+	//Start is called before call is sent or request is handled,
+	// returned context is used for the call and passed to End, its traceparent is sent with message
+	Start(ctx context.Context, m *Message) context.Context
+	//End is called when call or handler is finished, err of server is a error responce of handler
+	End(ctx context.Context, m *Message, d time.Duration, err error)
+}
+
+//WithTracer trace every call of sync client
+func WithTracer(t Tracer) Option {
+	return func(cfg *config) {
+		cfg.tracer = t
+	}
+}
+
+type traceparentKey struct{}
+
+//ContextWithTraceparent return context which carry traceparent, calls with the context send it in metadata
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+//TraceparentFromContext return traceparent of context, server put traceparent of request into context of message
+func TraceparentFromContext(ctx context.Context) string {
+	tp, _ := ctx.Value(traceparentKey{}).(string)
+	return tp
+}
+
+//NewTraceparent create traceparent with new span ID, trace ID and flags are kept from valid parent
+// otherwise new sampled trace is started
+func NewTraceparent(parent string) string {
+	var span [8]byte
+	rand.Read(span[:])
+	if ValidTraceparent(parent) {
+		return parent[:36] + hex.EncodeToString(span[:]) + parent[52:]
+	}
+	var trace [16]byte
+	rand.Read(trace[:])
+	return "00-" + hex.EncodeToString(trace[:]) + "-" + hex.EncodeToString(span[:]) + "-01"
+}
+
+//ValidTraceparent check format of version 00 traceparent, trace and span IDs should not be zero
+func ValidTraceparent(tp string) bool {
+	if len(tp) != 55 || tp[:3] != "00-" || tp[35] != '-' || tp[52] != '-' {
+		return false
+	}
+	return lowerHex(tp[3:35]) && lowerHex(tp[36:52]) && lowerHex(tp[53:]) &&
+		tp[3:35] != "00000000000000000000000000000000" && tp[36:52] != "0000000000000000"
+}
+
+func lowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+//injectTraceparent put traceparent of ctx into metadata of message unless it already has one
+func injectTraceparent(ctx context.Context, m *Message) {
+	tp := TraceparentFromContext(ctx)
+	if tp == "" || m.Metadata[TraceparentKey] != "" {
+		return
+	}
+	md := make(map[string]string, len(m.Metadata)+1)
+	for k, v := range m.Metadata {
+		md[k] = v
+	}
+	md[TraceparentKey] = tp
+	m.Metadata = md
+}
+
+//extractTraceparent return context with valid traceparent of received message
+func extractTraceparent(ctx context.Context, m *Message) context.Context {
+	if tp := m.Metadata[TraceparentKey]; ValidTraceparent(tp) {
+		return ContextWithTraceparent(ctx, tp)
+	}
+	return ctx
+}
@@ -0,0 +1,120 @@
+package fdstream
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceEvent struct {
+	name, traceparent string
+	err               error
+}
+
+//testTracer start child span of context traceparent and record finished spans
+type testTracer struct {
+	l     sync.Mutex
+	spans []traceEvent
+}
+
+func (t *testTracer) Start(ctx context.Context, m *Message) context.Context {
+	return ContextWithTraceparent(ctx, NewTraceparent(TraceparentFromContext(ctx)))
+}
+
+func (t *testTracer) End(ctx context.Context, m *Message, d time.Duration, err error) {
+	t.l.Lock()
+	t.spans = append(t.spans, traceEvent{m.Name, TraceparentFromContext(ctx), err})
+	t.l.Unlock()
+}
+
+func (t *testTracer) events() []traceEvent {
+	t.l.Lock()
+	defer t.l.Unlock()
+	return append([]traceEvent(nil), t.spans...)
+}
+
+func TestTraceparent(t *testing.T) {
+	as := assert.New(t)
+	root := NewTraceparent("")
+	as.True(ValidTraceparent(root))
+	as.Equal("00-", root[:3])
+	as.Equal("-01", root[52:])
+
+	child := NewTraceparent(root)
+	as.True(ValidTraceparent(child))
+	as.Equal(root[:36], child[:36])
+	as.NotEqual(root[36:52], child[36:52])
+
+	as.True(ValidTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	as.False(ValidTraceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01"))
+	as.False(ValidTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"))
+	as.False(ValidTraceparent("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"))
+	as.False(ValidTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	as.False(ValidTraceparent("garbage"))
+}
+
+func TestTracePropagation(t *testing.T) {
+	as := assert.New(t)
+	serverTracer, clientTracer := new(testTracer), new(testTracer)
+	var handled string
+	s := &Server{
+		Tracer: serverTracer,
+		Handler: HandlerFunc(func(m *Message) *Message {
+			handled = m.Metadata[TraceparentKey]
+			if m.Name == "fail" {
+				return &Message{Code: CodeGeneralError, Name: "failed"}
+			}
+			return &Message{Name: m.Name}
+		}),
+	}
+	defer s.Close()
+	a, b := net.Pipe()
+	go s.ServeConn(b)
+	cl, err := NewSyncClient(a, a, time.Second, WithTracer(clientTracer))
+	as.Nil(err)
+	defer cl.Shutdown()
+
+	parent := NewTraceparent("")
+	ctx := ContextWithTraceparent(context.Background(), parent)
+	_, err = cl.Call(ctx, &Message{Name: "ok", Metadata: map[string]string{"k": "v"}})
+	as.Nil(err)
+	_, err = cl.Call(ctx, &Message{Name: "fail"})
+	as.NotNil(err)
+
+	calls := clientTracer.events()
+	as.Len(calls, 2)
+	as.Equal("ok", calls[0].name)
+	as.Equal(parent[:36], calls[0].traceparent[:36])
+	as.NotEqual(parent, calls[0].traceparent)
+	as.Nil(calls[0].err)
+	as.Equal(err, calls[1].err)
+
+	as.True(waitFor(func() bool { return len(serverTracer.events()) == 2 }))
+	served := serverTracer.events()
+	as.Equal(parent[:36], served[0].traceparent[:36])
+	as.NotEqual(calls[0].traceparent, served[0].traceparent)
+	as.Nil(served[0].err)
+	code, _ := ErrorCode(served[1].err)
+	as.Equal(CodeGeneralError, code)
+	as.Equal(calls[1].traceparent, handled)
+}
+
+func TestTraceparentWithoutTracer(t *testing.T) {
+	as := assert.New(t)
+	a, b, err := Pipe(WithClientOptions(WithFragmentSize(1024)))
+	as.Nil(err)
+	defer a.Shutdown()
+	defer b.Shutdown()
+	cl := newSyncClient(a, a.ToReadQ, time.Second)
+	go cl.synchronizationWorker()
+
+	tp := NewTraceparent("")
+	go cl.Call(ContextWithTraceparent(context.Background(), tp), &Message{Name: "big", Payload: make([]byte, 4096)})
+	m := <-b.ToReadQ
+	as.Len(m.Payload, 4096)
+	as.Equal(map[string]string{TraceparentKey: tp}, m.Metadata)
+}